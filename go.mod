@@ -4,6 +4,7 @@ go 1.17
 
 require (
 	github.com/Azure/azure-extension-platform v0.0.0-20220805171336-0dc957a76b67
+	github.com/coreos/go-systemd/v22 v22.5.0
 	github.com/go-kit/kit v0.12.0
 	github.com/stretchr/testify v1.7.0
 )
@@ -12,6 +13,7 @@ require (
 	github.com/davecgh/go-spew v1.1.0 // indirect
 	github.com/go-kit/log v0.2.0 // indirect
 	github.com/go-logfmt/logfmt v0.5.1 // indirect
+	github.com/godbus/dbus/v5 v5.1.0 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	golang.org/x/sys v0.0.0-20220209214540-3681064d5158 // indirect
@@ -22,19 +22,12 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"context"
-	"encoding/json"
-	"encoding/xml"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
-	"strconv"
 	"strings"
 	"time"
 
@@ -45,8 +38,8 @@ import (
 
 const (
 	extensionName                 = "AHBForSLES"
-	extensionVersion              = "0.0.0.3"
-	DEFAULT_SHELL_COMMAND_TIMEOUT = 120 //seconds
+	extensionVersion              = "0.0.0.4"
+	DEFAULT_SHELL_COMMAND_TIMEOUT = 120 * time.Second
 )
 const (
 	INSTALL_EVENT            = "Install"
@@ -60,20 +53,22 @@ const (
 	OPERATION_COMPLETION_MSG = "AHBForSLES extension %s completed. Result=Success"
 )
 
+// Defaults come from getAhbInfo, every field can be overridden
+// in the public settings using its JSON name
 type AHBInfo struct {
-	PublicCloudService     string
-	RegisterCloudGuestPath string
-	RegionSrvMinVer        string
-	RegionSrvEnablerTimer  string
-	RegionSrv              string
-	RegionSrvAddOn         string
-	RegionSrvPlugin        string
-	RegionSrvConfig        string
-	RegionSrvCerts         string
-	AddonPath              string
-	RepoAlias              string
-	ModName                string
-	RepoUrl                string
+	PublicCloudService     string `json:"publicCloudService"`
+	RegisterCloudGuestPath string `json:"registerCloudGuestPath"`
+	RegionSrvMinVer        string `json:"regionSrvMinVer"`
+	RegionSrvEnablerTimer  string `json:"regionSrvEnablerTimer"`
+	RegionSrv              string `json:"regionSrv"`
+	RegionSrvAddOn         string `json:"regionSrvAddOn"`
+	RegionSrvPlugin        string `json:"regionSrvPlugin"`
+	RegionSrvConfig        string `json:"regionSrvConfig"`
+	RegionSrvCerts         string `json:"regionSrvCerts"`
+	AddonPath              string `json:"addonPath"`
+	RepoAlias              string `json:"repoAlias"`
+	ModName                string `json:"modName"`
+	RepoUrl                string `json:"repoUrl"`
 }
 
 func parseCfg(filename string) (map[string]map[string]string, error) {
@@ -111,81 +106,67 @@ func parseCfg(filename string) (map[string]map[string]string, error) {
 	return ini, nil
 }
 
-func _getUsernameAndPassword() (string, string) {
-	sccCredentialsFile, err := os.Open("/etc/zypp/credentials.d/SCCcredentials")
-	username := ""
-	pass := ""
+func _getSubscriptionStatus(host *Host, credentialsPath string) (bool, error) {
+	credentials, err := _getSCCCredentials(host, credentialsPath)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		return username, pass
-	}
-	scanner := bufio.NewScanner(sccCredentialsFile)
-	for scanner.Scan() {
-		line := scanner.Text()
-		start := strings.Index(string(line), "=") + 1
-		if start != -1 {
-			if strings.Contains(line, "user") {
-				username = string(line)[start:len(line)]
-			}
-			if strings.Contains(line, "pass") {
-				pass = string(line)[start:len(line)]
-			}
-		}
-		if username != "" && pass != "" {
-			break
-		}
+		return false, err
+	}
+	client := newSCCClient(credentials.Username, credentials.Password)
+	client.BaseUrl = host.SCCUrl
+	client.SystemToken = credentials.SystemToken
+	subscriptions, err := client.GetSubscriptions()
+	if errors.Is(err, ErrSCCNoSubscriptions) {
+		fmt.Fprintln(host.Output, "System has no subscriptions")
+		return false, nil
 	}
-	return username, pass
-}
-
-func _getSubscriptionStatus() (bool, error) {
-	active := false
-	sccConnectUrl := "https://scc.suse.com/connect"
-	URL := sccConnectUrl + "/systems/subscriptions"
-	req, err := http.NewRequest("GET", URL, nil)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		return active, err
+		return false, err
+	}
+	_reportSubscriptions(host.Output, subscriptions)
+	for _, subscription := range subscriptions {
+		if subscription.IsActive() {
+			return true, nil
+		}
 	}
-	req.Header.Add("accept", "application/json")
-	username, pass := _getUsernameAndPassword()
-	req.SetBasicAuth(username, pass)
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	return false, nil
+}
+
+func _getSUSEConnectStatus(host *Host, rmt *RMTSettings, credentialsPath string) (bool, bool, error) {
+	products, err := newSUSEConnectClient(host, rmt).Status()
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
-		return active, err
-	}
-	bodyText, err := ioutil.ReadAll(resp.Body)
-	var suseConnectSubscriptions []map[string]interface{}
-	json.Unmarshal([]byte(bodyText), &suseConnectSubscriptions)
-	status := fmt.Sprintf("%v", suseConnectSubscriptions[0]["status"])
-	if strings.ToLower(status) == "active" {
-		active = true
+		return false, false, err
 	}
-	return active, nil
+	return _getRegistrationStatus(host, products, rmt, credentialsPath)
 }
 
-func _getSUSEConnectStatus() (bool, bool, error) {
-	commandOutput, error := RunShellCommand(0, "SUSEConnect", "-s")
+// Whether the products reported by SUSEConnect --status are registered
+// and have an active subscription
+func _getRegistrationStatus(host *Host, products []SUSEConnectProduct, rmt *RMTSettings, credentialsPath string) (bool, bool, error) {
 	registered := false
-	active := false
-	if error != nil {
-		fmt.Fprintln(os.Stderr, error)
-	} else {
-		var suseConnectStatus []map[string]interface{}
-		json.Unmarshal([]byte(commandOutput), &suseConnectStatus)
-		status := fmt.Sprintf("%v", suseConnectStatus[0]["status"])
-		if strings.ToLower(status) == "registered" {
-			registered = true
-			active, error = _getSubscriptionStatus()
-		}
-	}
-	return registered, active, error
+	for _, product := range products {
+		fmt.Fprintf(host.Output, "Product %s: status=%s subscription=%s expires=%s\n",
+			product.Triplet(), product.Status, product.SubscriptionStatus, product.ExpiresAt)
+		// modules can only be registered on top of a
+		// registered base product
+		registered = registered || product.IsRegistered()
+	}
+	if !registered {
+		return false, false, nil
+	}
+	if rmt != nil {
+		// subscriptions are managed by the RMT server,
+		// SCC is not reachable from here
+		return true, true, nil
+	}
+	active, err := _getSubscriptionStatus(host, credentialsPath)
+	return true, active, err
 }
 
-func _hasPubCloudMod(pubCloudService string) (bool, error) {
-	services, err := filepath.Glob("/etc/zypp/services.d/*.service")
+func _hasPubCloudMod(host *Host, pubCloudService string) (bool, error) {
+	services, err := filepath.Glob(filepath.Join(host.zyppServicesDir(), "*.service"))
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return false, err
@@ -201,129 +182,97 @@ func _hasPubCloudMod(pubCloudService string) (bool, error) {
 	}
 	return false, nil
 }
-func _isNewerVersion(versions []string) bool {
-	installed := strings.Split(versions[0], ".")
-	minVer := strings.Split(versions[1], ".")
-	if len(installed) != len(minVer) || len(installed) != 3 {
-		return versions[0] > versions[1]
-	}
-
-	installedInt := make([]int, len(installed))
-	minVerInt := make([]int, len(minVer))
-	for i := range installed {
-		installedInt[i], _ = strconv.Atoi(installed[i])
-		minVerInt[i], _ = strconv.Atoi(minVer[i])
-	}
-
-	// MAYOR.MINOR.PATCH
-	// If MAJOR and MINOR are the same, compare PATCH
-	if installedInt[0] == minVerInt[0] && installedInt[1] == minVerInt[1] {
-		return installedInt[2] >= minVerInt[2]
-	}
-
-	// If MAJOR is same, compare MINOR
-	if installedInt[0] == minVerInt[0] {
-		return installedInt[1] >= minVerInt[1]
-	}
-
-	// Compare MAJOR
-	return installedInt[0] >= minVerInt[0]
-}
-
-func _checkVersion(ahbInfo AHBInfo) bool {
-	type Solvable struct {
-		Name    string `xml:"name,attr"`
-		Edition string `xml:"edition,attr"`
-	}
-
-	type ZypperOutput struct {
-		XMLName   xml.Name   `xml:"stream"`
-		Solvables []Solvable `xml:"search-result>solvable-list>solvable"`
-	}
 
-	var info ZypperOutput
-	xmlData, err := RunShellCommand(0, "zypper", "-x", "search", "-is", ahbInfo.RegionSrv)
-	err = xml.Unmarshal([]byte(xmlData), &info)
+// All the AHB packages are installed and cloud-regionsrv-client is
+// at least RegionSrvMinVer
+func _checkVersion(host *Host, ahbInfo AHBInfo) bool {
+	installed, err := _getInstalledPackages(host, _getAhbPackageNames(ahbInfo)...)
 	if err != nil {
-		fmt.Printf("error: %v", err)
+		fmt.Fprintln(host.Output, "error:", err)
 		return false
 	}
-	for _, solvable := range info.Solvables {
-		if solvable.Name == ahbInfo.RegionSrv {
-			solvable.Edition = strings.Split(solvable.Edition, "-")[0]
-			versionsToCompare := []string{solvable.Edition, ahbInfo.RegionSrvMinVer}
-			if !_isNewerVersion(versionsToCompare) {
-				return false
-			} else {
-				return true
-			}
+	for _, name := range _getAhbPackageNames(ahbInfo) {
+		if _, found := installed[name]; !found {
+			fmt.Fprintln(host.Output, "Package", name, "is not installed")
+			return false
 		}
 	}
-	return false
+	regionSrv := installed[ahbInfo.RegionSrv]
+	return _compareEVR(regionSrv.EVR(), ahbInfo.RegionSrvMinVer) >= 0
 }
 
-func _addRepo(repoAlias string, repoUrl string) error {
-	_, err := RunShellCommand(0, "zypper", "addrepo", repoUrl, repoAlias)
+func _addRepo(host *Host, repoAlias string, repoUrl string, state *StateStore) error {
+	err := newZypperClient(host).AddRepo(repoUrl, repoAlias)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Error while adding a repo with URL:", repoUrl)
+		return err
 	}
-	return err
+	return state.repoAdded(repoAlias)
 }
 
-func _installPackages(ahbInfo AHBInfo) error {
-	regionSrv := fmt.Sprintf("%s>=%s", ahbInfo.RegionSrv, ahbInfo.RegionSrvMinVer)
-	_, err := RunShellCommand(0, "zypper", "--non-interactive", "in", "--replacefiles",
-		"--no-recommends", regionSrv, ahbInfo.RegionSrvAddOn, ahbInfo.RegionSrvPlugin,
-		ahbInfo.RegionSrvConfig, ahbInfo.RegionSrvCerts)
+func _removeRepo(host *Host, repoAlias string, state *StateStore) error {
+	err := newZypperClient(host).RemoveRepo(repoAlias)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error installing", ahbInfo.RegionSrv, "or", ahbInfo.RegionSrvAddOn)
+		fmt.Fprintln(os.Stderr, "Error when removing repo", repoAlias)
 		return err
 	}
-	return nil
+	return state.repoRemoved(repoAlias)
 }
 
-func _getVersionAndArch(module bool) (string, string) {
-	output, _ := RunShellCommand(0, "uname", "-i")
-	arch := strings.Trim(string(output), "\n\t\r")
-	catCommand := "cat /etc/os-release | grep VERSION_ID | tr -d VERSION_ID= | tr -d '\"'"
-	output, _ = RunShellCommand(0, "bash", "-c", catCommand)
-	version := ""
-	if strings.Contains(string(output), "15") {
-		if module {
-			version = output
-		} else {
-			version = "15"
+func _installPackages(host *Host, ahbInfo AHBInfo, state *StateStore) error {
+	packages := _getAhbPackageNames(ahbInfo)
+	installedBefore, err := _getInstalledPackages(host, packages...)
+	if err != nil {
+		return err
+	}
+	regionSrv := fmt.Sprintf("%s>=%s", ahbInfo.RegionSrv, ahbInfo.RegionSrvMinVer)
+	err = newZypperClient(host).Install(regionSrv, ahbInfo.RegionSrvAddOn, ahbInfo.RegionSrvPlugin,
+		ahbInfo.RegionSrvConfig, ahbInfo.RegionSrvCerts)
+	if errors.Is(err, ErrZypperRebootNeeded) {
+		fmt.Println("Packages installed, zypper reports that a reboot is needed")
+		err = nil
+	}
+	// record what got installed even on failure, a partial
+	// transaction may have left some of the packages behind
+	installedAfter, queryError := _getInstalledPackages(host, packages...)
+	var stateError error
+	for name := range installedAfter {
+		if _, found := installedBefore[name]; !found {
+			if saveError := state.packageInstalled(name); saveError != nil {
+				stateError = saveError
+			}
 		}
 	}
-	if strings.Contains(string(output), "12") {
-		version = "12"
+	if queryError == nil {
+		if saveError := state.packageVersions(installedAfter); saveError != nil {
+			stateError = saveError
+		}
 	}
-	return version, arch
-}
-func _getUnrestrictedRepoUrl(ahbRepoUrl string) string {
-	version, arch := _getVersionAndArch(false)
-	return fmt.Sprintf(ahbRepoUrl, version, arch)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error installing", ahbInfo.RegionSrv, "or", ahbInfo.RegionSrvAddOn)
+		return err
+	}
+	return stateError
 }
 
-func _installUnrestrictedRepoPackages(ahbInfo AHBInfo, repoUrl string, ext *vmextension.VMExtension) error {
-	repoError := _addRepo(ahbInfo.RepoAlias, repoUrl)
-	if repoError == nil {
-		// install cloud-regionsrv-client and addon packages
-		return _installPackages(ahbInfo)
+func _getUnrestrictedRepoUrl(host *Host, ahbRepoUrl string, rmt *RMTSettings) (string, error) {
+	release, err := _getSLESRelease(host)
+	if err != nil {
+		return "", err
 	}
-	ext.ExtensionEvents.LogErrorEvent(
-		INSTALL_EVENT,
-		fmt.Sprintf(OPERATION_FAILURE_MSG, "Add unrestricted repo", repoError.Error()))
-
-	return repoError
+	if rmt != nil {
+		ahbRepoUrl = _getRMTRepoUrl(rmt, ahbRepoUrl)
+	}
+	return fmt.Sprintf(ahbRepoUrl, release.RepoVersion(), release.Arch), nil
 }
 
-func _removeRepositories() error {
-	repos, err := filepath.Glob("/etc/zypp/repos.d/*.repo")
+func _removeRepositories(host *Host, state *StateStore) error {
+	repos, err := filepath.Glob(filepath.Join(host.zyppReposDir(), "*.repo"))
 	if err != nil {
-		fmt.Fprintln(os.Stderr, "Error getting repositories from '/etc/zypp/repos.d'")
+		fmt.Fprintln(os.Stderr, "Error getting repositories from '"+host.zyppReposDir()+"'")
 		return err
 	}
+	var backup *RepoBackup
 	for _, repo := range repos {
 		repoFile, err := os.Open(repo)
 
@@ -337,9 +286,37 @@ func _removeRepositories() error {
 			if strings.Contains(scanner.Text(), "baseurl") && (strings.Contains(scanner.Text(), "plugin:/susecloud") ||
 				strings.Contains(scanner.Text(), "plugin:susecloud")) {
 				fmt.Println("Removing repo ", repo)
-				os.Remove(repo)
+				if backup == nil {
+					backup, err = _newRepoBackup(state.dataDir(), "Subscription expired, removing susecloud repos")
+					if err == nil {
+						err = backup.addServices(host.zyppServicesDir())
+					}
+					if err != nil {
+						fmt.Fprintln(os.Stderr, "Error creating backup of the repos:", err)
+						repoFile.Close()
+						return err
+					}
+					fmt.Println("Backing up repos to", backup.Dir)
+				}
+				// no deleting without a backup
+				if err = backup.add(repo, true); err != nil {
+					fmt.Fprintln(os.Stderr, "Error backing up repo", repo+":", err)
+					repoFile.Close()
+					return err
+				}
+				if err = os.Remove(repo); err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					repoFile.Close()
+					return err
+				}
+				if err = state.repoFileRemoved(repo, backup.Dir); err != nil {
+					repoFile.Close()
+					return err
+				}
+				break
 			}
 		}
+		repoFile.Close()
 
 		if err := scanner.Err(); err != nil {
 			fmt.Fprintln(os.Stderr, err)
@@ -349,8 +326,8 @@ func _removeRepositories() error {
 	return nil
 }
 
-func _hasServices() (bool, error) {
-	services, err := filepath.Glob("/etc/zypp/services.d/*.service")
+func _hasServices(host *Host) (bool, error) {
+	services, err := filepath.Glob(filepath.Join(host.zyppServicesDir(), "*.service"))
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return false, err
@@ -358,122 +335,47 @@ func _hasServices() (bool, error) {
 	return len(services) > 0, nil
 }
 
-func _reactivateServices() error {
-	extensionsOutput, err := RunShellCommand(0, "SUSEConnect", "--list-extensions")
+func _reactivateServices(host *Host, rmt *RMTSettings) error {
+	client := newSUSEConnectClient(host, rmt)
+	extensions, err := client.ListExtensions()
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		return err
 	}
-	extensionsList := strings.Split(extensionsOutput, "\n")
-	for _, extension := range extensionsList {
-		if strings.Contains(extension, "Deactivate with") {
-			// activate whatever it was active
-			start := strings.Index(string(extension), "SUSEConnect")
-			if start != -1 {
-				command := string(extension)[start:len(extension)]
-				commandList := strings.Split(command, " ")
-				_, err = RunShellCommand(0, commandList[0], commandList[2], commandList[3])
-				if err != nil {
-					fmt.Fprintln(os.Stderr, err)
-					return err
-				}
+	// activate whatever it was active
+	_walkExtensions(extensions, func(extension *SUSEConnectExtension) {
+		if err == nil && extension.Activated {
+			if err = client.Activate(extension.Triplet); err != nil {
+				fmt.Fprintln(os.Stderr, err)
 			}
 		}
-	}
-	return nil
+	})
+	return err
 }
 
-func _activatePubCloudModule(ahbInfo AHBInfo) (bool, error) {
-	version, arch := _getVersionAndArch(true)
-	triplet := ahbInfo.ModName + "/" + version + "/" + arch
-	_, addModuleError := RunShellCommand(0, "SUSEConnect", "-p", triplet)
-	if addModuleError != nil {
-		// adding module with SUSEConnect failed,
-		// trying adding repo with zypper
-		repoUrl := fmt.Sprintf(ahbInfo.RepoUrl, version, arch)
-		addRepoError := _addRepo(ahbInfo.RepoAlias, repoUrl)
-		if addRepoError != nil {
-			return false, addRepoError
-		}
-		return true, nil
-	}
-	return false, nil
-}
-
-func _handlePackageInstall(ahbInfo AHBInfo, ext *vmextension.VMExtension) error {
-	isRegistered, hasActiveSubscription, err := _getSUSEConnectStatus()
-
-	if err != nil {
-		return err
-	}
-
-	if isRegistered && hasActiveSubscription {
-		// system is registered with an active subscription
-		// check if services are present
-		hasServices, err := _hasServices()
-		if err != nil {
-			return err
-		}
-
-		if !hasServices {
-			err = _reactivateServices()
-			if err != nil {
-				return err
-			}
-		}
-		hasPubCloudMod, errGlob := _hasPubCloudMod(ahbInfo.PublicCloudService)
-		if errGlob != nil {
-			return errGlob
-		}
-		removeRepo := false
-		if !hasPubCloudMod {
-			removeRepo, err = _activatePubCloudModule(ahbInfo)
-			if err != nil {
-				return err
-			}
-		}
-		// install cloud-regionsrv-client and addon packages
-		if installError := _installPackages(ahbInfo); installError != nil {
-			ext.ExtensionEvents.LogErrorEvent(
-				INSTALL_EVENT,
-				fmt.Sprintf(OPERATION_FAILURE_MSG, "Install packages", installError.Error()))
-			return installError
-		}
-		if removeRepo {
-			// packages installed, remove repo
-			_, err = RunShellCommand(0, "zypper", "removerepo", ahbInfo.RepoAlias)
-			if err != nil {
-				fmt.Fprintln(os.Stderr, "Error when removing repo", ahbInfo.RepoAlias)
-			}
-		}
-	} else {
-		if isRegistered && !hasActiveSubscription {
-			fmt.Println("System is registered but subscription expired. Removing repositories")
-			_removeRepositories()
-		}
-		if !isRegistered {
-			fmt.Println("System is not registered")
-		}
-		fmt.Println("Adding repository and installing packages")
-		repoUrl := _getUnrestrictedRepoUrl(ahbInfo.RepoUrl)
-		err := _installUnrestrictedRepoPackages(ahbInfo, repoUrl, ext)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "Error installing packages from Unrestricted repository")
-			_, repoError := RunShellCommand(0, "zypper", "removerepo", ahbInfo.RepoAlias)
-			if repoError != nil {
-				fmt.Fprintln(os.Stderr, "Error when removing repo", ahbInfo.RepoAlias)
-				return repoError
-			}
-			return err
-		}
-		// packages installed, remove repo
-		_, err = RunShellCommand(0, "zypper", "removerepo", ahbInfo.RepoAlias)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "Error when removing repo", ahbInfo.RepoAlias)
-		}
-
-	}
-	return nil
+// Activate the module with SUSEConnect, fall back to adding the given repo
+func _activatePubCloudModule(host *Host, triplet string, repoAlias string, repoUrl string, rmt *RMTSettings, state *StateStore) error {
+	client := newSUSEConnectClient(host, rmt)
+	addModuleError := client.Activate(triplet)
+	if addModuleError == nil {
+		return state.moduleActivated(triplet, client.Url)
+	}
+	var commandError *CommandError
+	switch {
+	case !errors.As(addModuleError, &commandError):
+		return addModuleError
+	case commandError.TimedOut:
+		// SUSEConnect may have added the module service before it
+		// got killed, the repo on top of it would be a duplicate
+		return fmt.Errorf("Activating %s did not finish: %v", triplet, addModuleError)
+	case commandError.ExitCode == SUSECONNECT_EXIT_CONNECTION_REFUSED && rmt != nil:
+		// the repo is mirrored by the same server, no use trying it
+		return fmt.Errorf("RMT server refused the connection: %v", addModuleError)
+	}
+	// adding module with SUSEConnect failed,
+	// trying adding repo with zypper
+	fmt.Println("Could not activate", triplet, "with SUSEConnect, exit code", commandError.ExitCode, "- adding repo", repoAlias)
+	return _addRepo(host, repoAlias, repoUrl, state)
 }
 
 func getAhbInfo() AHBInfo {
@@ -494,60 +396,84 @@ func getAhbInfo() AHBInfo {
 	}
 }
 
+// The host for a callback, waits for the zypp lock are reported as
+// events of the running operation
+func _newHostForEvent(ext *vmextension.VMExtension, event string) *Host {
+	host := newHost()
+	host.OnZyppLockWait = func(holder ZyppLockHolder, wait time.Duration) {
+		message := _zyppLockWaitMessage(holder, wait)
+		fmt.Println(message)
+		ext.ExtensionEvents.LogInformationalEvent(event, message)
+	}
+	return host
+}
+
 var installCallbackFunc vmextension.CallbackFunc = func(ext *vmextension.VMExtension) error {
 
 	ext.ExtensionEvents.LogInformationalEvent(
 		INSTALL_EVENT,
 		fmt.Sprintf(OPERATION_START_MSG, INSTALL_EVENT))
-	ahbInfo := getAhbInfo()
-	// 1. Check if the system has the public cloud module
-	_, registercloudError := os.Stat(ahbInfo.RegisterCloudGuestPath)
-	_, addonError := os.Stat(ahbInfo.AddonPath)
-	if registercloudError == nil {
-		if !_checkVersion(ahbInfo) {
-			// need to install the right version
-			handlePackageError := _handlePackageInstall(ahbInfo, ext)
-			if handlePackageError != nil {
-				fmt.Fprintln(os.Stderr, "Extension install failed. Reason="+handlePackageError.Error())
-				ext.ExtensionEvents.LogErrorEvent(
-					INSTALL_EVENT,
-					fmt.Sprintf(OPERATION_FAILURE_MSG, INSTALL_EVENT, handlePackageError.Error()))
-				return handlePackageError
-			}
-		} else {
-			if addonError == nil {
-				// both packages are in the system and
-				// the version is correct
-				ext.ExtensionEvents.LogInformationalEvent(
-					INSTALL_EVENT,
-					fmt.Sprintf(OPERATION_COMPLETION_MSG, INSTALL_EVENT))
-				fmt.Println("Extension install succeeded")
-				return nil
-			} else {
-				// missing addon package
-				// add addon
-				handlePackageError := _handlePackageInstall(ahbInfo, ext)
-				if handlePackageError != nil {
-					fmt.Fprintln(os.Stderr, "Extension install failed. Reason="+handlePackageError.Error())
-					ext.ExtensionEvents.LogErrorEvent(
-						INSTALL_EVENT,
-						fmt.Sprintf(OPERATION_FAILURE_MSG, INSTALL_EVENT, handlePackageError.Error()))
-					return handlePackageError
-				}
-			}
-		}
-	} else {
-		handlePackageError := _handlePackageInstall(ahbInfo, ext)
-		if handlePackageError != nil {
-			fmt.Fprintln(os.Stderr, "Extension install failed. Reason="+handlePackageError.Error())
-			ext.ExtensionEvents.LogErrorEvent(
-				INSTALL_EVENT,
-				fmt.Sprintf(OPERATION_FAILURE_MSG, INSTALL_EVENT, handlePackageError.Error()))
-
-			return handlePackageError
-		}
+	host := _newHostForEvent(ext, INSTALL_EVENT)
+	if err := _runPreflightChecks(host); err != nil {
+		fmt.Fprintln(os.Stderr, "Extension install failed. Reason="+err.Error())
+		ext.ExtensionEvents.LogErrorEvent(
+			INSTALL_EVENT,
+			fmt.Sprintf(OPERATION_FAILURE_MSG, INSTALL_EVENT, err.Error()))
+		return err
+	}
+	settings, err := getPublicSettings(ext)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Extension install failed. Reason="+err.Error())
+		ext.ExtensionEvents.LogErrorEvent(
+			INSTALL_EVENT,
+			fmt.Sprintf(OPERATION_FAILURE_MSG, INSTALL_EVENT, err.Error()))
+		return err
+	}
+	rmt, err := getRMTSettings(ext)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Extension install failed. Reason="+err.Error())
+		ext.ExtensionEvents.LogErrorEvent(
+			INSTALL_EVENT,
+			fmt.Sprintf(OPERATION_FAILURE_MSG, INSTALL_EVENT, err.Error()))
+		return err
+	}
+	state, err := _loadState(ext.HandlerEnv.DataFolder)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Extension install failed. Reason="+err.Error())
+		ext.ExtensionEvents.LogErrorEvent(
+			INSTALL_EVENT,
+			fmt.Sprintf(OPERATION_FAILURE_MSG, INSTALL_EVENT, err.Error()))
+		return err
+	}
+	// 1. Work out what needs to be done
+	plan, err := _planInstall(host, settings, rmt)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Extension install failed. Reason="+err.Error())
+		ext.ExtensionEvents.LogErrorEvent(
+			INSTALL_EVENT,
+			fmt.Sprintf(OPERATION_FAILURE_MSG, INSTALL_EVENT, err.Error()))
+		return err
+	}
+	if settings.DryRun {
+		_reportDryRun(plan, ext)
+		return nil
+	}
+	// 2. Do it
+	if err = _executeInstallPlan(host, plan, ext.ExtensionEvents, state); err != nil {
+		fmt.Fprintln(os.Stderr, "Extension install failed. Reason="+err.Error())
+		ext.ExtensionEvents.LogErrorEvent(
+			INSTALL_EVENT,
+			fmt.Sprintf(OPERATION_FAILURE_MSG, INSTALL_EVENT, err.Error()))
+		return err
 	}
 
+	if err = state.extensionVersion(extensionVersion); err != nil {
+		fmt.Fprintln(os.Stderr, "Extension install failed. Reason="+err.Error())
+		ext.ExtensionEvents.LogErrorEvent(
+			INSTALL_EVENT,
+			fmt.Sprintf(OPERATION_FAILURE_MSG, INSTALL_EVENT, err.Error()))
+		return err
+	}
 	ext.ExtensionEvents.LogInformationalEvent(
 		INSTALL_EVENT,
 		fmt.Sprintf(OPERATION_COMPLETION_MSG, INSTALL_EVENT))
@@ -559,48 +485,317 @@ var enableCallbackFunc vmextension.EnableCallbackFunc = func(ext *vmextension.VM
 	ext.ExtensionEvents.LogInformationalEvent(
 		ENABLE_EVENT,
 		fmt.Sprintf(OPERATION_START_MSG, ENABLE_EVENT))
+	host := _newHostForEvent(ext, ENABLE_EVENT)
+	if err := _runPreflightChecks(host); err != nil {
+		fmt.Fprintln(os.Stderr, "Extension enable failed. Reason="+err.Error())
+		ext.ExtensionEvents.LogErrorEvent(
+			ENABLE_EVENT,
+			fmt.Sprintf(OPERATION_FAILURE_MSG, ENABLE_EVENT, err.Error()))
+		// the status message is what the user sees in the portal
+		return _newEarlyEnableStatus("failure", "preflight", SUBSTATUS_ERROR, err.Error()).String(), err
+	}
 
-	ahbInfo := getAhbInfo()
+	settings, err := getPublicSettings(ext)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Extension enable failed. Reason="+err.Error())
+		ext.ExtensionEvents.LogErrorEvent(
+			ENABLE_EVENT,
+			fmt.Sprintf(OPERATION_FAILURE_MSG, ENABLE_EVENT, err.Error()))
+		return _newEarlyEnableStatus("failure", "settings", SUBSTATUS_ERROR, err.Error()).String(), err
+	}
+	ahbInfo := settings.AHBInfo
+	if settings.DryRun {
+		message := "Dry run, timer " + ahbInfo.RegionSrvEnablerTimer + " was not enabled"
+		fmt.Println(message)
+		ext.ExtensionEvents.LogInformationalEvent(ENABLE_EVENT, message)
+		return _newEarlyEnableStatus("dry-run", "dryRun", SUBSTATUS_WARNING, message).String(), nil
+	}
 	//1. double check that the regionsrv-enabler-azure.service file exists
 	status := "success"
-	_, err := os.Stat(ahbInfo.AddonPath)
+	_, err = os.Stat(ahbInfo.AddonPath)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Extension enable failed. Reason="+err.Error())
 		ext.ExtensionEvents.LogErrorEvent(
 			ENABLE_EVENT,
 			fmt.Sprintf(OPERATION_FAILURE_MSG, ENABLE_EVENT, err.Error()))
-		return "failure", err
+		return _newEarlyEnableStatus("failure", "addon", SUBSTATUS_ERROR, "Enabler not found: "+err.Error()).String(), err
 	}
 	//2. enable and start the timer
-	systemdActions := []string{"enable", "start"}
-	for _, systemdAction := range systemdActions {
-		_, err = RunShellCommand(0, "systemctl", systemdAction, ahbInfo.RegionSrvEnablerTimer)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, "Error when trying to", systemdAction, " timer", ahbInfo.RegionSrvEnablerTimer)
+	manager := host.systemdManager()
+	defer manager.Close()
+	if err = _enableTimer(manager, ahbInfo.RegionSrvEnablerTimer); err != nil {
+		fmt.Fprintln(os.Stderr, "Error when trying to enable timer", ahbInfo.RegionSrvEnablerTimer+":", err)
+		ext.ExtensionEvents.LogErrorEvent(
+			ENABLE_EVENT,
+			fmt.Sprintf(OPERATION_FAILURE_MSG, ENABLE_EVENT, err.Error()))
+		status = "failure"
+	}
+	//3. make sure the enabler does its job
+	var enablerErr error
+	if status == "success" && settings.VerifyEnabler {
+		timeout := time.Duration(settings.VerifyEnablerTimeout) * time.Second
+		if enablerErr = _verifyEnablerRun(host, manager, ahbInfo.RegionSrvEnablerTimer, timeout); enablerErr != nil {
+			fmt.Fprintln(os.Stderr, "Error verifying the enabler:", enablerErr)
 			ext.ExtensionEvents.LogErrorEvent(
 				ENABLE_EVENT,
-				fmt.Sprintf(OPERATION_FAILURE_MSG, ENABLE_EVENT, err.Error()))
+				fmt.Sprintf(OPERATION_FAILURE_MSG, ENABLE_EVENT, enablerErr.Error()))
 			status = "failure"
+			err = enablerErr
 		}
 	}
 	fmt.Println(status, "when enabling the extension")
+	state, stateErr := _loadState(ext.HandlerEnv.DataFolder)
+	if stateErr != nil {
+		fmt.Fprintln(os.Stderr, "Error loading extension state:", stateErr)
+		state = nil
+	}
 	if status == "success" {
+		if state != nil {
+			// disable does not touch enabledByUser, update goes by it
+			stateErr = _updateTimerState(state, manager, ahbInfo.RegionSrvEnablerTimer)
+			if stateErr == nil {
+				stateErr = state.enabledByUser(true)
+			}
+			if stateErr != nil {
+				// the timer runs, update may not bring it back
+				fmt.Fprintln(os.Stderr, stateErr)
+				ext.ExtensionEvents.LogErrorEvent(ENABLE_EVENT, stateErr.Error())
+			}
+		}
+		// a missing heartbeat does not make the enable fail
+		if err := _reportHeartbeat(manager, ext.HandlerEnv.HeartbeatFile, ahbInfo.RegionSrvEnablerTimer); err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing heartbeat:", err)
+		}
+		if err := _startHeartbeat(ext.HandlerEnv.DataFolder, ext.HandlerEnv.HeartbeatFile, ahbInfo.RegionSrvEnablerTimer); err != nil {
+			fmt.Fprintln(os.Stderr, "Error starting heartbeat reporter:", err)
+			ext.ExtensionEvents.LogErrorEvent(ENABLE_EVENT, "Heartbeat reporting not started: "+err.Error())
+		}
 		ext.ExtensionEvents.LogInformationalEvent(
 			ENABLE_EVENT,
 			fmt.Sprintf(OPERATION_COMPLETION_MSG, ENABLE_EVENT))
 	}
-	return status, err
+	// the status message is what the user sees in the portal
+	enableStatus := _getEnableStatus(host, status, ahbInfo, state, manager)
+	if enablerErr != nil {
+		enableStatus.add("enabler", SUBSTATUS_ERROR, enablerErr.Error())
+	} else if settings.VerifyEnabler && status == "success" {
+		enableStatus.add("enabler", SUBSTATUS_SUCCESS, "Enabler ran successfully")
+	}
+	return enableStatus.String(), err
+}
+
+var uninstallCallbackFunc vmextension.CallbackFunc = func(ext *vmextension.VMExtension) error {
+	ext.ExtensionEvents.LogInformationalEvent(
+		UNINSTALL_EVENT,
+		fmt.Sprintf(OPERATION_START_MSG, UNINSTALL_EVENT))
+	host := _newHostForEvent(ext, UNINSTALL_EVENT)
+
+	state, err := _loadState(ext.HandlerEnv.DataFolder)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Extension uninstall failed. Reason="+err.Error())
+		ext.ExtensionEvents.LogErrorEvent(
+			UNINSTALL_EVENT,
+			fmt.Sprintf(OPERATION_FAILURE_MSG, UNINSTALL_EVENT, err.Error()))
+		return err
+	}
+	if state.State.ExtensionVersion != "" &&
+		_compareExtensionVersions(state.State.ExtensionVersion, extensionVersion) > 0 {
+		// the guest agent uninstalls the old version after updating
+		// to the new one, the changes now belong to the new version
+		fmt.Println("Extension was updated to version", state.State.ExtensionVersion, "keeping the changes")
+		ext.ExtensionEvents.LogInformationalEvent(
+			UNINSTALL_EVENT,
+			fmt.Sprintf(OPERATION_COMPLETION_MSG, UNINSTALL_EVENT))
+		return nil
+	}
+	_stopHeartbeat(ext.HandlerEnv.DataFolder)
+	// undo the changes in the reverse order install did them,
+	// keep going on errors so that as much as possible is rolled back
+	var uninstallError error
+	logError := func(step string, err error) {
+		fmt.Fprintln(os.Stderr, "Error when trying to", step+":", err)
+		ext.ExtensionEvents.LogErrorEvent(
+			UNINSTALL_EVENT,
+			fmt.Sprintf(OPERATION_FAILURE_MSG, step, err.Error()))
+		if uninstallError == nil {
+			uninstallError = err
+		}
+	}
+	if err = state.enabledByUser(false); err != nil {
+		logError("clear the enabled flag", err)
+	}
+	_undoChanges(host, state.State.copy(), state, logError)
+
+	if uninstallError != nil {
+		// what could not be rolled back stays in the
+		// state for the next attempt
+		return uninstallError
+	}
+	if err = state.delete(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error deleting extension state:", err)
+	}
+	ext.ExtensionEvents.LogInformationalEvent(
+		UNINSTALL_EVENT,
+		fmt.Sprintf(OPERATION_COMPLETION_MSG, UNINSTALL_EVENT))
+	fmt.Println("Extension uninstall succeeded")
+	return nil
 }
 
 var updateCallbackFunc vmextension.CallbackFunc = func(ext *vmextension.VMExtension) error {
-	// optional
-	// on update, the extension will call this code
+	ext.ExtensionEvents.LogInformationalEvent(
+		UPDATE_EVENT,
+		fmt.Sprintf(OPERATION_START_MSG, UPDATE_EVENT))
+	host := _newHostForEvent(ext, UPDATE_EVENT)
+
+	settings, err := getPublicSettings(ext)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Extension update failed. Reason="+err.Error())
+		ext.ExtensionEvents.LogErrorEvent(
+			UPDATE_EVENT,
+			fmt.Sprintf(OPERATION_FAILURE_MSG, UPDATE_EVENT, err.Error()))
+		return err
+	}
+	rmt, err := getRMTSettings(ext)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Extension update failed. Reason="+err.Error())
+		ext.ExtensionEvents.LogErrorEvent(
+			UPDATE_EVENT,
+			fmt.Sprintf(OPERATION_FAILURE_MSG, UPDATE_EVENT, err.Error()))
+		return err
+	}
+	state, err := _loadState(ext.HandlerEnv.DataFolder)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Extension update failed. Reason="+err.Error())
+		ext.ExtensionEvents.LogErrorEvent(
+			UPDATE_EVENT,
+			fmt.Sprintf(OPERATION_FAILURE_MSG, UPDATE_EVENT, err.Error()))
+		return err
+	}
+	previousVersion := _getPreviousExtensionVersion(state)
+	reportedVersion := previousVersion
+	if reportedVersion == "" {
+		reportedVersion = "unknown"
+	}
+	ext.ExtensionEvents.LogInformationalEvent(
+		UPDATE_EVENT,
+		fmt.Sprintf("Updating AHBForSLES extension from version '%s' to '%s'", reportedVersion, extensionVersion))
+
+	if err = _runExtensionMigrations(host, settings, rmt, ext.ExtensionEvents, state, previousVersion); err != nil {
+		fmt.Fprintln(os.Stderr, "Extension update failed. Reason="+err.Error())
+		ext.ExtensionEvents.LogErrorEvent(
+			UPDATE_EVENT,
+			fmt.Sprintf(OPERATION_FAILURE_MSG, UPDATE_EVENT, err.Error()))
+		return err
+	}
+	// from now on the state belongs to this version, see uninstall
+	if err = state.extensionVersion(extensionVersion); err != nil {
+		fmt.Fprintln(os.Stderr, "Extension update failed. Reason="+err.Error())
+		ext.ExtensionEvents.LogErrorEvent(
+			UPDATE_EVENT,
+			fmt.Sprintf(OPERATION_FAILURE_MSG, UPDATE_EVENT, err.Error()))
+		return err
+	}
+
+	ext.ExtensionEvents.LogInformationalEvent(
+		UPDATE_EVENT,
+		fmt.Sprintf(OPERATION_COMPLETION_MSG, UPDATE_EVENT))
+	fmt.Println("Extension update succeeded")
 	return nil
 }
 
+// Properties of a unit as systemctl show reports them
+func _getUnitProperties(host *Host, unit string, properties ...string) (map[string]string, error) {
+	args := []string{"show"}
+	for _, property := range properties {
+		args = append(args, "-p", property)
+	}
+	output, err := host.RunShellCommand(0, "systemctl", append(args, unit)...)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]string)
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.SplitN(strings.TrimSpace(line), "=", 2)
+		if len(fields) == 2 {
+			values[fields[0]] = fields[1]
+		}
+	}
+	return values, nil
+}
+
+// Save the current state of the timer in the state journal
+func _updateTimerState(state *StateStore, manager SystemdManager, timer string) error {
+	status, err := manager.Status(timer)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error getting the state of timer", timer+":", err)
+	}
+	return state.timerState(timer, status.IsEnabled(), status.IsActive())
+}
+
+func _recordTimerState(ext *vmextension.VMExtension, manager SystemdManager, timer string) {
+	state, err := _loadState(ext.HandlerEnv.DataFolder)
+	if err == nil {
+		err = _updateTimerState(state, manager, timer)
+	}
+	if err != nil {
+		// the timer is disabled all the same
+		fmt.Fprintln(os.Stderr, "Error recording the timer state:", err)
+		ext.ExtensionEvents.LogErrorEvent(DISABLE_EVENT, "Timer state not recorded: "+err.Error())
+	}
+}
+
+// Stop and disable the timer. A timer systemd has not loaded, because
+// install failed or the addon was never installed, is already disabled.
+func _disableEnablerTimer(manager SystemdManager, timer string) error {
+	timerStatus, err := manager.Status(timer)
+	if err == nil && !timerStatus.IsLoaded() {
+		fmt.Println("Timer", timer, "is", timerStatus.LoadState+", nothing to disable")
+		return nil
+	}
+	if err = _disableTimer(manager, timer); err != nil {
+		fmt.Fprintln(os.Stderr, "Error when trying to disable timer", timer+":", err)
+		return err
+	}
+	// double check that the timer is not running anymore
+	timerStatus, err = manager.Status(timer)
+	if err == nil && timerStatus.ActiveState != "inactive" {
+		err = fmt.Errorf("Timer %s is still %s after stopping it", timer, timerStatus.ActiveState)
+	}
+	return err
+}
+
 var disableCallbackFunc vmextension.CallbackFunc = func(ext *vmextension.VMExtension) error {
-	// optional
-	// on disable, the extension will call this code
+	ext.ExtensionEvents.LogInformationalEvent(
+		DISABLE_EVENT,
+		fmt.Sprintf(OPERATION_START_MSG, DISABLE_EVENT))
+
+	ahbInfo, err := getAhbInfoFromSettings(ext)
+	if err != nil {
+		// the timer has to stop all the same, with broken settings
+		// the default is the best guess for its name
+		ahbInfo = getAhbInfo()
+		fmt.Fprintln(os.Stderr, "Invalid settings, disabling timer", ahbInfo.RegionSrvEnablerTimer+":", err)
+		ext.ExtensionEvents.LogWarningEvent(
+			DISABLE_EVENT,
+			fmt.Sprintf("Invalid settings, disabling timer %s: %v", ahbInfo.RegionSrvEnablerTimer, err))
+	}
+	//1. stop reporting heartbeats, then stop and disable the timer
+	_stopHeartbeat(ext.HandlerEnv.DataFolder)
+	manager := _newHostForEvent(ext, DISABLE_EVENT).systemdManager()
+	defer manager.Close()
+	if err = _disableEnablerTimer(manager, ahbInfo.RegionSrvEnablerTimer); err != nil {
+		fmt.Fprintln(os.Stderr, "Extension disable failed. Reason="+err.Error())
+		ext.ExtensionEvents.LogErrorEvent(
+			DISABLE_EVENT,
+			fmt.Sprintf(OPERATION_FAILURE_MSG, DISABLE_EVENT, err.Error()))
+		return err
+	}
+
+	_recordTimerState(ext, manager, ahbInfo.RegionSrvEnablerTimer)
+	ext.ExtensionEvents.LogInformationalEvent(
+		DISABLE_EVENT,
+		fmt.Sprintf(OPERATION_COMPLETION_MSG, DISABLE_EVENT))
+	fmt.Println("Extension disable succeeded")
 	return nil
 }
 
@@ -610,6 +805,18 @@ var getInitializationInfoFuncToCall = vmextension.GetInitializationInfo
 var logger = log.NewSyncLogger(log.NewLogfmtLogger(os.Stdout))
 
 func main() {
+	// diagnose, restore-repos and heartbeat are run by hand or by a
+	// timer, not by the guest agent; diagnose reads the settings of the
+	// installed extension itself
+	if len(os.Args) > 1 && os.Args[1] == DIAGNOSE_COMMAND {
+		os.Exit(runDiagnose(os.Stdout))
+	}
+	if len(os.Args) > 1 && os.Args[1] == RESTORE_REPOS_COMMAND {
+		os.Exit(runRestoreRepos(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == HEARTBEAT_COMMAND {
+		os.Exit(runHeartbeat(os.Args[2:]))
+	}
 	err := getExtensionAndRun()
 	if err != nil {
 		os.Exit(exithelper.EnvironmentError)
@@ -625,6 +832,7 @@ func getExtensionAndRun() error {
 	initilizationInfo.InstallCallback = installCallbackFunc
 	initilizationInfo.DisableCallback = disableCallbackFunc
 	initilizationInfo.UpdateCallback = updateCallbackFunc
+	initilizationInfo.UninstallCallback = uninstallCallbackFunc
 	vmExt, err := getVMExtensionFuncToCall(initilizationInfo)
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "Extension initialization failed. Reason="+err.Error())
@@ -637,32 +845,45 @@ func getExtensionAndRun() error {
 	return nil
 }
 
-// Function to run a shell command through golang
-func RunShellCommand(timeout time.Duration, name string, args ...string) (string, error) {
+// Function to run a shell command through the command runner of the host
+func (host *Host) RunShellCommand(timeout time.Duration, name string, args ...string) (string, error) {
+	output, _, err := host.RunShellCommandWithExitCode(timeout, name, args...)
+	if err != nil {
+		return "", err
+	}
+	return output, nil
+}
+
+// Same as RunShellCommand, but the exit code and the output are
+// returned for failed commands too. The exit code is -1 when the
+// command did not run or timed out. Errors are *CommandError.
+func (host *Host) RunShellCommandWithExitCode(timeout time.Duration, name string, args ...string) (string, int, error) {
 
 	if timeout == 0 {
 		timeout = DEFAULT_SHELL_COMMAND_TIMEOUT
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), timeout*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
-	cmd := exec.CommandContext(ctx, name, args...)
-	var outb, errb bytes.Buffer
-	cmd.Stdout = &outb
-	cmd.Stderr = &errb
-	err := cmd.Run()
-
-	if ctx.Err() == context.DeadlineExceeded {
-		err = errors.New("Timeout running shell command: " + name + " " + strings.Join(args, " "))
-		fmt.Fprintln(os.Stderr, err)
-		return "", err
-	}
-
-	if err != nil {
-		err = errors.New("Error running shell command: " + name + " " + strings.Join(args, " ") + ". Error: " + errb.String())
-		fmt.Fprintln(os.Stderr, err)
-		return "", err
-	}
-
-	return outb.String(), nil
+	start := time.Now()
+	result, err := host.Runner.Run(ctx, name, args...)
+	if err == nil {
+		return result.Stdout, 0, nil
+	}
+
+	commandError := &CommandError{
+		Command:  name,
+		Args:     args,
+		ExitCode: result.ExitCode,
+		Stdout:   result.Stdout,
+		Stderr:   result.Stderr,
+		Duration: time.Since(start),
+		TimedOut: ctx.Err() == context.DeadlineExceeded,
+		Err:      err,
+	}
+	if commandError.TimedOut {
+		commandError.ExitCode = -1
+	}
+	fmt.Fprintln(os.Stderr, commandError)
+	return result.Stdout, commandError.ExitCode, commandError
 }
@@ -0,0 +1,181 @@
+// Copyright (c) 2022, SUSE LLC, All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	BACKUPS_DIR           = "backups"
+	BACKUP_MANIFEST_FILE  = "manifest.json"
+	RESTORE_REPOS_COMMAND = "restore-repos"
+)
+
+// A file copied into a backup. Removed files are written back on
+// restore, the others only when they are missing.
+type BackupEntry struct {
+	Path    string      `json:"path"`
+	File    string      `json:"file"`
+	Mode    os.FileMode `json:"mode"`
+	Removed bool        `json:"removed"`
+}
+
+type BackupManifest struct {
+	Created time.Time     `json:"created"`
+	Reason  string        `json:"reason"`
+	Entries []BackupEntry `json:"entries"`
+}
+
+// Timestamped directory below the data dir holding copies of zypp
+// configuration files and a manifest describing them
+type RepoBackup struct {
+	Dir      string
+	Manifest BackupManifest
+}
+
+func _newRepoBackup(dataDir string, reason string) (*RepoBackup, error) {
+	now := time.Now().UTC()
+	backup := &RepoBackup{
+		Dir:      filepath.Join(dataDir, BACKUPS_DIR, now.Format("20060102T150405.000000000Z")),
+		Manifest: BackupManifest{Created: now, Reason: reason, Entries: []BackupEntry{}},
+	}
+	if err := os.MkdirAll(backup.Dir, 0700); err != nil {
+		return nil, err
+	}
+	return backup, backup.saveManifest()
+}
+
+func (backup *RepoBackup) saveManifest() error {
+	content, err := json.MarshalIndent(backup.Manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(backup.Dir, BACKUP_MANIFEST_FILE), content, 0600)
+}
+
+// Copy the file into the backup and record it in the manifest
+func (backup *RepoBackup) add(path string, removed bool) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	name := strings.ReplaceAll(strings.TrimPrefix(path, "/"), "/", "_")
+	if err = ioutil.WriteFile(filepath.Join(backup.Dir, name), content, 0600); err != nil {
+		return err
+	}
+	backup.Manifest.Entries = append(backup.Manifest.Entries, BackupEntry{
+		Path:    path,
+		File:    name,
+		Mode:    info.Mode().Perm(),
+		Removed: removed,
+	})
+	return backup.saveManifest()
+}
+
+// Back up the zypp services too, they are what brings the removed
+// repos back and are useful to have next to them
+func (backup *RepoBackup) addServices(servicesDir string) error {
+	services, err := filepath.Glob(filepath.Join(servicesDir, "*.service"))
+	if err != nil {
+		return err
+	}
+	for _, service := range services {
+		if err = backup.add(service, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write the files of a backup back in place, only the given paths when
+// there are any. Returns the restored paths.
+func _restoreRepoBackup(dir string, paths ...string) ([]string, error) {
+	restored := []string{}
+	content, err := ioutil.ReadFile(filepath.Join(dir, BACKUP_MANIFEST_FILE))
+	if err != nil {
+		return restored, err
+	}
+	manifest := BackupManifest{}
+	if err = json.Unmarshal(content, &manifest); err != nil {
+		return restored, fmt.Errorf("Could not parse backup manifest in '%v': %v", dir, err)
+	}
+	entries := manifest.Entries
+	if len(paths) > 0 {
+		entries = []BackupEntry{}
+		for _, path := range paths {
+			found := false
+			for _, entry := range manifest.Entries {
+				if entry.Path == path {
+					entries = append(entries, entry)
+					found = true
+				}
+			}
+			if !found {
+				return restored, fmt.Errorf("'%v' is not in the backup '%v'", path, dir)
+			}
+		}
+	}
+	for _, entry := range entries {
+		if _, err = os.Stat(entry.Path); err == nil && !entry.Removed {
+			continue
+		}
+		content, err = ioutil.ReadFile(filepath.Join(dir, entry.File))
+		if err != nil {
+			return restored, err
+		}
+		if err = ioutil.WriteFile(entry.Path, content, entry.Mode); err != nil {
+			return restored, err
+		}
+		restored = append(restored, entry.Path)
+	}
+	return restored, nil
+}
+
+// The restore-repos command, puts back the files of the given backup
+func runRestoreRepos(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage:", filepath.Base(os.Args[0]), RESTORE_REPOS_COMMAND, "<backup directory>")
+		return 2
+	}
+	restored, err := _restoreRepoBackup(args[0])
+	for _, path := range restored {
+		fmt.Println("Restored", path)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error restoring backup:", err)
+		return 1
+	}
+	if len(restored) == 0 {
+		fmt.Println("Nothing to restore")
+	}
+	return 0
+}
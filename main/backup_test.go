@@ -0,0 +1,89 @@
+// Copyright (c) 2022, SUSE LLC, All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func _writeTestFile(t *testing.T, path string, content string, mode os.FileMode) {
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), mode))
+	require.NoError(t, os.Chmod(path, mode))
+}
+
+func TestRestoreRepoBackupSelectedPaths(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first.repo")
+	second := filepath.Join(dir, "second.repo")
+	_writeTestFile(t, first, "[first]\n", 0600)
+	_writeTestFile(t, second, "[second]\n", 0644)
+
+	backup, err := _newRepoBackup(filepath.Join(dir, "data"), "test")
+	require.NoError(t, err)
+	require.NoError(t, backup.add(first, true))
+	require.NoError(t, backup.add(second, true))
+	require.NoError(t, os.Remove(first))
+	require.NoError(t, os.Remove(second))
+
+	restored, err := _restoreRepoBackup(backup.Dir, first)
+	require.NoError(t, err)
+	assert.Equal(t, []string{first}, restored)
+	content, err := ioutil.ReadFile(first)
+	require.NoError(t, err)
+	assert.Equal(t, "[first]\n", string(content))
+	info, err := os.Stat(first)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	_, err = os.Stat(second)
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = _restoreRepoBackup(backup.Dir, filepath.Join(dir, "other.repo"))
+	assert.Error(t, err)
+}
+
+func TestUndoChangesRestoresRemovedRepos(t *testing.T) {
+	dir := t.TempDir()
+	repo := filepath.Join(dir, "SLE-Module-Basesystem.repo")
+	_writeTestFile(t, repo, "[basesystem]\nbaseurl=plugin:/susecloud?path=/repo\n", 0640)
+
+	state, err := _loadState(filepath.Join(dir, "data"))
+	require.NoError(t, err)
+	backup, err := _newRepoBackup(state.dataDir(), "test")
+	require.NoError(t, err)
+	require.NoError(t, backup.add(repo, true))
+	require.NoError(t, os.Remove(repo))
+	require.NoError(t, state.repoFileRemoved(repo, backup.Dir))
+
+	_undoChanges(newTestHost(t, &ScriptedCommandRunner{}), state.State.copy(), state, func(step string, err error) {
+		t.Errorf("%s: %v", step, err)
+	})
+
+	info, err := os.Stat(repo)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+	assert.Empty(t, state.State.RemovedRepos)
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2022, SUSE LLC, All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Output of a command, ExitCode is -1 when the command did not run to
+// completion
+type CommandResult struct {
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+	ExitCode int    `json:"exitCode"`
+}
+
+// Runs the external commands of the extension. The error is only set
+// when the command could not be run or did not exit 0, the result is
+// filled in as far as the command got.
+type CommandRunner interface {
+	Run(ctx context.Context, name string, args ...string) (CommandResult, error)
+}
+
+// A command that failed or timed out, with everything it printed
+type CommandError struct {
+	Command string
+	Args    []string
+	// -1 when the command did not run or timed out
+	ExitCode int
+	Stdout   string
+	Stderr   string
+	Duration time.Duration
+	TimedOut bool
+	// what running the command returned
+	Err error
+}
+
+func (e *CommandError) Error() string {
+	commandLine := strings.TrimSpace(e.Command + " " + strings.Join(e.Args, " "))
+	if e.TimedOut {
+		return fmt.Sprintf("Timeout running shell command: %s after %v", commandLine, e.Duration.Round(time.Millisecond))
+	}
+	if e.ExitCode < 0 {
+		return fmt.Sprintf("Error running shell command: %s. Error: %v", commandLine, e.Err)
+	}
+	return fmt.Sprintf("Error running shell command: %s. Exit code: %d. Error: %s", commandLine, e.ExitCode, strings.TrimSpace(e.Stderr))
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// Runs the commands on the system
+type ExecCommandRunner struct{}
+
+func (runner ExecCommandRunner) Run(ctx context.Context, name string, args ...string) (CommandResult, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	var outb, errb bytes.Buffer
+	cmd.Stdout = &outb
+	cmd.Stderr = &errb
+	err := cmd.Run()
+
+	result := CommandResult{Stdout: outb.String(), Stderr: errb.String(), ExitCode: 0}
+	if err != nil {
+		result.ExitCode = -1
+		var exitError *exec.ExitError
+		if errors.As(err, &exitError) && ctx.Err() == nil {
+			result.ExitCode = exitError.ExitCode()
+		}
+	}
+	return result, err
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2022, SUSE LLC, All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Command the scripted runner expects next and what it answers. Args
+// nil matches any arguments.
+type ScriptedCommand struct {
+	Name   string        `json:"name"`
+	Args   []string      `json:"args"`
+	Result CommandResult `json:"result"`
+}
+
+// Replays recorded command output instead of running anything, to drive
+// the install and enable logic without a SLES system. Commands have to
+// come in the scripted order.
+type ScriptedCommandRunner struct {
+	Commands []ScriptedCommand
+	// command lines run so far
+	Calls []string
+	next  int
+	mutex sync.Mutex
+}
+
+// Read a script, a JSON list of ScriptedCommand
+func newScriptedCommandRunner(script io.Reader) (*ScriptedCommandRunner, error) {
+	runner := &ScriptedCommandRunner{}
+	if err := json.NewDecoder(script).Decode(&runner.Commands); err != nil {
+		return nil, fmt.Errorf("Invalid command script: %v", err)
+	}
+	return runner, nil
+}
+
+func (runner *ScriptedCommandRunner) Run(ctx context.Context, name string, args ...string) (CommandResult, error) {
+	runner.mutex.Lock()
+	defer runner.mutex.Unlock()
+	commandLine := strings.TrimSpace(name + " " + strings.Join(args, " "))
+	runner.Calls = append(runner.Calls, commandLine)
+	if runner.next >= len(runner.Commands) {
+		return CommandResult{ExitCode: -1}, fmt.Errorf("Unexpected command '%s', script is done", commandLine)
+	}
+	command := runner.Commands[runner.next]
+	if command.Name != name || (command.Args != nil && strings.Join(command.Args, "\x00") != strings.Join(args, "\x00")) {
+		return CommandResult{ExitCode: -1}, fmt.Errorf("Unexpected command '%s', expected '%s %s'",
+			commandLine, command.Name, strings.Join(command.Args, " "))
+	}
+	runner.next++
+	if err := ctx.Err(); err != nil {
+		return CommandResult{ExitCode: -1}, err
+	}
+	if command.Result.ExitCode != 0 {
+		return command.Result, fmt.Errorf("exit status %d", command.Result.ExitCode)
+	}
+	return command.Result, nil
+}
+
+// Whether every scripted command was run
+func (runner *ScriptedCommandRunner) Done() bool {
+	runner.mutex.Lock()
+	defer runner.mutex.Unlock()
+	return runner.next == len(runner.Commands)
+}
+
+// Runner replaying main/testdata/<name>.json
+func loadScriptedCommandRunner(t *testing.T, name string) *ScriptedCommandRunner {
+	script, err := os.Open(filepath.Join("testdata", name+".json"))
+	require.NoError(t, err)
+	defer script.Close()
+	runner, err := newScriptedCommandRunner(script)
+	require.NoError(t, err)
+	return runner
+}
+
+// Host with empty zypp directories and a SLES 15 SP4 os-release below a
+// temporary directory, commands go to the given runner and systemd is
+// reached through systemctl
+func newTestHost(t *testing.T, runner CommandRunner) *Host {
+	dir := t.TempDir()
+	host := newHost()
+	host.Runner = runner
+	host.ZyppDir = filepath.Join(dir, "zypp")
+	host.OSReleaseFile = filepath.Join(dir, "os-release")
+	host.SCCUrl = "http://scc.invalid"
+	host.RMTCaCertificatePath = filepath.Join(dir, "ahb-extension-rmt-server.pem")
+	host.connectSystemd = func(host *Host) SystemdManager {
+		return &SystemctlManager{host: host}
+	}
+	for _, zyppDir := range []string{host.zyppReposDir(), host.zyppServicesDir(), host.zyppCredentialsDir()} {
+		require.NoError(t, os.MkdirAll(zyppDir, 0755))
+	}
+	_writeTestFile(t, host.OSReleaseFile, `NAME="SLES"
+VERSION="15-SP4"
+VERSION_ID="15.4"
+ID="sles"
+ID_LIKE="suse"
+CPE_NAME="cpe:/o:suse:sles:15:sp4"
+`, 0644)
+	return host
+}
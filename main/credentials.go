@@ -0,0 +1,153 @@
+// Copyright (c) 2022, SUSE LLC, All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const SCC_CREDENTIALS_FILE = "SCCcredentials"
+
+// Credentials from a zypp credentials file
+type ZyppCredentials struct {
+	Path        string
+	Username    string
+	Password    string
+	SystemToken string
+}
+
+// Returned when no usable credentials were found, callers can tell it
+// apart from I/O errors with errors.As
+type MissingCredentialsError struct {
+	Paths  []string
+	Reason string
+}
+
+func (e *MissingCredentialsError) Error() string {
+	return fmt.Sprintf("No system credentials found in %s: %s", strings.Join(e.Paths, ", "), e.Reason)
+}
+
+// Parse the zypp credentials format: key=value lines with username,
+// password and the optional system_token, blank lines and # comments.
+// Section headers are allowed, only the first set of keys is used.
+func _parseCredentials(reader io.Reader) (ZyppCredentials, error) {
+	credentials := ZyppCredentials{}
+	scanner := bufio.NewScanner(reader)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			if credentials.Username != "" {
+				break
+			}
+			continue
+		}
+		separator := strings.Index(line, "=")
+		if separator == -1 {
+			return credentials, fmt.Errorf("Invalid credentials line %d: missing '='", lineNumber)
+		}
+		key := strings.TrimSpace(line[:separator])
+		value := strings.TrimSpace(line[separator+1:])
+		switch key {
+		case "username":
+			credentials.Username = value
+		case "password":
+			credentials.Password = value
+		case "system_token":
+			credentials.SystemToken = value
+		}
+	}
+	return credentials, scanner.Err()
+}
+
+func _readCredentialsFile(path string) (ZyppCredentials, error) {
+	credentialsFile, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return ZyppCredentials{}, &MissingCredentialsError{Paths: []string{path}, Reason: "file does not exist"}
+	}
+	if err != nil {
+		return ZyppCredentials{}, err
+	}
+	defer credentialsFile.Close()
+
+	credentials, err := _parseCredentials(credentialsFile)
+	if err != nil {
+		return credentials, fmt.Errorf("Could not parse '%v': %v", path, err)
+	}
+	credentials.Path = path
+	if credentials.Username == "" || credentials.Password == "" {
+		return credentials, &MissingCredentialsError{Paths: []string{path}, Reason: "username or password is empty"}
+	}
+	return credentials, nil
+}
+
+// Credentials files to look at in order: the given names first, then the
+// per-service files zypp keeps for every service in services.d
+func _getCredentialsCandidates(credentialsDir string, servicesDir string, names ...string) []string {
+	candidates := []string{}
+	for _, name := range names {
+		if filepath.IsAbs(name) {
+			candidates = append(candidates, name)
+		} else {
+			candidates = append(candidates, filepath.Join(credentialsDir, name))
+		}
+	}
+	services, _ := filepath.Glob(filepath.Join(servicesDir, "*.service"))
+	for _, service := range services {
+		name := strings.TrimSuffix(filepath.Base(service), ".service")
+		candidates = append(candidates, filepath.Join(credentialsDir, name))
+	}
+	return candidates
+}
+
+func _findCredentials(candidates []string) (ZyppCredentials, error) {
+	missing := &MissingCredentialsError{Reason: "no file with a username and password"}
+	for _, candidate := range candidates {
+		credentials, err := _readCredentialsFile(candidate)
+		if err == nil {
+			return credentials, nil
+		}
+		if _, ok := err.(*MissingCredentialsError); !ok {
+			return credentials, err
+		}
+		missing.Paths = append(missing.Paths, candidate)
+	}
+	return ZyppCredentials{}, missing
+}
+
+// System credentials used to talk to SCC, credentialsPath is looked at
+// first when set
+func _getSCCCredentials(host *Host, credentialsPath string) (ZyppCredentials, error) {
+	names := []string{SCC_CREDENTIALS_FILE}
+	if credentialsPath != "" {
+		names = append([]string{credentialsPath}, names...)
+	}
+	return _findCredentials(_getCredentialsCandidates(host.zyppCredentialsDir(), host.zyppServicesDir(), names...))
+}
@@ -0,0 +1,92 @@
+// Copyright (c) 2022, SUSE LLC, All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCredentials(t *testing.T) {
+	credentials, err := _parseCredentials(strings.NewReader(`
+# written by SUSEConnect
+  username = SCC_0123
+password=se=cret
+system_token=token
+`))
+	require.NoError(t, err)
+	assert.Equal(t, "SCC_0123", credentials.Username)
+	assert.Equal(t, "se=cret", credentials.Password)
+	assert.Equal(t, "token", credentials.SystemToken)
+
+	_, err = _parseCredentials(strings.NewReader("username SCC_0123\n"))
+	assert.Error(t, err)
+}
+
+func TestFindCredentialsPrefersConfiguredPath(t *testing.T) {
+	dir := t.TempDir()
+	credentialsDir := filepath.Join(dir, "credentials.d")
+	servicesDir := filepath.Join(dir, "services.d")
+	require.NoError(t, os.MkdirAll(credentialsDir, 0700))
+	require.NoError(t, os.MkdirAll(servicesDir, 0700))
+	configured := filepath.Join(dir, "custom-credentials")
+	require.NoError(t, ioutil.WriteFile(configured, []byte("username=custom\npassword=pass\n"), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(credentialsDir, SCC_CREDENTIALS_FILE),
+		[]byte("username=scc\npassword=pass\n"), 0600))
+
+	credentials, err := _findCredentials(_getCredentialsCandidates(credentialsDir, servicesDir, configured, SCC_CREDENTIALS_FILE))
+	require.NoError(t, err)
+	assert.Equal(t, "custom", credentials.Username)
+	assert.Equal(t, configured, credentials.Path)
+
+	// a configured file that does not exist falls through to zypp's
+	credentials, err = _findCredentials(_getCredentialsCandidates(credentialsDir, servicesDir,
+		filepath.Join(dir, "missing"), SCC_CREDENTIALS_FILE))
+	require.NoError(t, err)
+	assert.Equal(t, "scc", credentials.Username)
+}
+
+func TestFindCredentialsPerServiceAndMissing(t *testing.T) {
+	dir := t.TempDir()
+	credentialsDir := filepath.Join(dir, "credentials.d")
+	servicesDir := filepath.Join(dir, "services.d")
+	require.NoError(t, os.MkdirAll(credentialsDir, 0700))
+	require.NoError(t, os.MkdirAll(servicesDir, 0700))
+
+	_, err := _findCredentials(_getCredentialsCandidates(credentialsDir, servicesDir, SCC_CREDENTIALS_FILE))
+	var missing *MissingCredentialsError
+	require.True(t, errors.As(err, &missing))
+	assert.Equal(t, []string{filepath.Join(credentialsDir, SCC_CREDENTIALS_FILE)}, missing.Paths)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(servicesDir, "Public_Cloud_Module_x86_64.service"), nil, 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(credentialsDir, "Public_Cloud_Module_x86_64"),
+		[]byte("username=service\npassword=pass\n"), 0600))
+	credentials, err := _findCredentials(_getCredentialsCandidates(credentialsDir, servicesDir, SCC_CREDENTIALS_FILE))
+	require.NoError(t, err)
+	assert.Equal(t, "service", credentials.Username)
+}
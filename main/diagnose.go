@@ -0,0 +1,176 @@
+// Copyright (c) 2022, SUSE LLC, All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	DIAGNOSE_COMMAND      = "diagnose"
+	DIAGNOSE_HTTP_TIMEOUT = 15 * time.Second
+)
+
+type DiagnosticCheck struct {
+	Name    string      `json:"name"`
+	Passed  bool        `json:"passed"`
+	Details interface{} `json:"details,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// What the install and enable paths would find on this VM
+type DiagnosticReport struct {
+	ExtensionVersion string            `json:"extensionVersion"`
+	Time             time.Time         `json:"time"`
+	Ready            bool              `json:"ready"`
+	Checks           []DiagnosticCheck `json:"checks"`
+}
+
+func (report *DiagnosticReport) add(name string, passed bool, details interface{}, err error) bool {
+	check := DiagnosticCheck{Name: name, Passed: passed && err == nil, Details: details}
+	if err != nil {
+		check.Error = err.Error()
+	}
+	report.Checks = append(report.Checks, check)
+	return check.Passed
+}
+
+// Any HTTP answer means the URL is reachable, the status is reported
+func _checkUrlReachable(URL string) (map[string]interface{}, bool, error) {
+	client := &http.Client{Timeout: DIAGNOSE_HTTP_TIMEOUT}
+	resp, err := client.Head(URL)
+	if err != nil {
+		return map[string]interface{}{"url": URL}, false, err
+	}
+	resp.Body.Close()
+	return map[string]interface{}{"url": URL, "status": resp.StatusCode}, true, nil
+}
+
+// The settings of the installed extension, read the way the callbacks
+// read them. The defaults are returned with the error when the handler
+// environment or the settings can't be read.
+func _loadDiagnoseSettings() (PublicSettings, *RMTSettings, error) {
+	defaults := PublicSettings{AHBInfo: getAhbInfo()}
+	initilizationInfo, err := getInitializationInfoFuncToCall(extensionName, extensionVersion, false, enableCallbackFunc)
+	if err != nil {
+		return defaults, nil, err
+	}
+	ext, err := getVMExtensionFuncToCall(initilizationInfo)
+	if err != nil {
+		return defaults, nil, err
+	}
+	settings, err := getPublicSettings(ext)
+	if err != nil {
+		return defaults, nil, err
+	}
+	rmt, err := getRMTSettings(ext)
+	if err != nil {
+		return settings, nil, err
+	}
+	return settings, rmt, nil
+}
+
+// Run every check the install path relies on without changing anything
+func _diagnose(host *Host, settings PublicSettings, rmt *RMTSettings, settingsError error) DiagnosticReport {
+	ahbInfo := settings.AHBInfo
+	report := DiagnosticReport{ExtensionVersion: extensionVersion, Time: time.Now().UTC()}
+
+	preflightPassed := report.add("preflight", true, nil, _runPreflightChecks(host))
+	settingsPassed := report.add("settings", true, map[string]interface{}{
+		"rmtServerUrl":    _rmtServerUrl(rmt),
+		"credentialsPath": settings.CredentialsPath,
+	}, settingsError)
+
+	products, err := newSUSEConnectClient(host, rmt).Status()
+	registered, active, statusError := false, false, err
+	if err == nil {
+		registered, active, statusError = _getRegistrationStatus(host, products, rmt, settings.CredentialsPath)
+	}
+	report.add("registration", registered, products, err)
+	report.add("subscription", active, nil, statusError)
+
+	hasServices, err := _hasServices(host)
+	report.add("services", hasServices, nil, err)
+
+	hasPubCloudMod, err := _hasPubCloudMod(host, ahbInfo.PublicCloudService)
+	report.add("publicCloudModule", hasPubCloudMod, nil, err)
+
+	installed, err := _getInstalledPackages(host, _getAhbPackageNames(ahbInfo)...)
+	packagesPassed := report.add("packages", err == nil && _checkVersion(host, ahbInfo), map[string]interface{}{
+		"minimumVersion": ahbInfo.RegionSrvMinVer,
+		"installed":      installed,
+	}, err)
+
+	manager := host.systemdManager()
+	timerStatus, err := manager.Status(ahbInfo.RegionSrvEnablerTimer)
+	manager.Close()
+	timerPassed := report.add("timer", timerStatus.IsEnabled() && timerStatus.IsActive(), timerStatus, err)
+
+	// with RMT the server takes the place of SCC
+	serverCheck, serverUrl := "sccReachable", host.SCCUrl
+	if rmt != nil {
+		serverCheck, serverUrl = "rmtReachable", rmt.ServerUrl
+	}
+	details, reachable, err := _checkUrlReachable(serverUrl)
+	report.add(serverCheck, reachable, details, err)
+	repoUrl, err := _getUnrestrictedRepoUrl(host, ahbInfo.RepoUrl, rmt)
+	if err == nil {
+		details, reachable, err = _checkUrlReachable(strings.TrimSuffix(repoUrl, "/") + "/repodata/repomd.xml")
+	}
+	report.add("unrestrictedRepoReachable", reachable, details, err)
+
+	report.Ready = preflightPassed && settingsPassed && packagesPassed && timerPassed
+	return report
+}
+
+func _rmtServerUrl(rmt *RMTSettings) string {
+	if rmt == nil {
+		return ""
+	}
+	return rmt.ServerUrl
+}
+
+// The diagnose command, prints the report as JSON and exits non zero
+// when the VM is not ready
+func runDiagnose(out io.Writer) int {
+	// keep the progress of the checks out of the report
+	host := newHost()
+	host.Output = os.Stderr
+	settings, rmt, settingsError := _loadDiagnoseSettings()
+	report := _diagnose(host, settings, rmt, settingsError)
+
+	content, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Fprintln(out, string(content))
+	if !report.Ready {
+		return 1
+	}
+	return 0
+}
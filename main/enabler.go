@@ -0,0 +1,146 @@
+// Copyright (c) 2022, SUSE LLC, All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ENABLER_VERIFY_TIMEOUT = 120 * time.Second
+	// the guest agent kills enable after 5 minutes, preflight, the
+	// settings and enabling the timer have to fit in there as well
+	ENABLER_VERIFY_MAX_TIMEOUT   = 180 * time.Second
+	ENABLER_VERIFY_POLL_INTERVAL = 2 * time.Second
+	ENABLER_JOURNAL_LINES        = 20
+)
+
+// The enabler service ran and did not succeed
+type EnablerRunError struct {
+	Service    string
+	Result     string
+	ExitStatus int
+	Journal    []string
+}
+
+func (e *EnablerRunError) Error() string {
+	message := fmt.Sprintf("%s failed with result '%s' and exit status %d", e.Service, e.Result, e.ExitStatus)
+	if len(e.Journal) > 0 {
+		message += ": " + strings.Join(e.Journal, "; ")
+	}
+	return message
+}
+
+// Last journal lines of the run, all of them if the invocation is known
+func _getEnablerJournal(host *Host, service string, invocationId string) []string {
+	args := []string{"--no-pager", "-o", "cat", "-n", strconv.Itoa(ENABLER_JOURNAL_LINES)}
+	if invocationId != "" {
+		args = append(args, "_SYSTEMD_INVOCATION_ID="+invocationId)
+	} else {
+		args = append(args, "-u", service)
+	}
+	output, err := host.RunShellCommand(0, "journalctl", args...)
+	if err != nil {
+		return nil
+	}
+	lines := []string{}
+	for _, line := range strings.Split(strings.TrimSpace(output), "\n") {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// Run the service behind the timer once and wait for it, so that enable
+// only succeeds when the switch to AHB really happened
+func _verifyEnablerRun(host *Host, manager SystemdManager, timer string, timeout time.Duration) error {
+	if timeout == 0 {
+		timeout = ENABLER_VERIFY_TIMEOUT
+	}
+	timerStatus, err := manager.Status(timer)
+	if err != nil {
+		return err
+	}
+	service := timerStatus.Unit
+	if service == "" {
+		return fmt.Errorf("Timer %s has no service", timer)
+	}
+	last, err := manager.ServiceStatus(service)
+	if err != nil {
+		return err
+	}
+
+	fmt.Println("Starting", service, "to verify it")
+	job, err := manager.StartNoWait(service)
+	if err != nil {
+		return err
+	}
+	jobResult := ""
+	deadline := time.Now().Add(timeout)
+	var status ServiceStatus
+	for {
+		if jobResult == "" {
+			select {
+			case jobResult = <-job:
+			default:
+			}
+		}
+		status, err = manager.ServiceStatus(service)
+		if err != nil {
+			return err
+		}
+		// a new exit timestamp means the run we started is over
+		exited := status.ExecMainExitTimestampMonotonic
+		if exited != last.ExecMainExitTimestampMonotonic && exited != 0 && status.ActiveState != "activating" {
+			break
+		}
+		// the job is over but the service did not get a new
+		// invocation, e.g. a failed dependency or a skipped
+		// condition, it is not going to run
+		if jobResult != "" && status.InvocationID == last.InvocationID &&
+			(status.ActiveState == "inactive" || status.ActiveState == "failed") {
+			return fmt.Errorf("%s did not run, start job result '%s', it is %s", service, jobResult, status.ActiveState)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s did not finish within %v, it is %s", service, timeout, status.ActiveState)
+		}
+		time.Sleep(ENABLER_VERIFY_POLL_INTERVAL)
+	}
+	if status.Result != "success" || status.ExecMainStatus != 0 {
+		journal := _getEnablerJournal(host, service, status.InvocationID)
+		for _, line := range journal {
+			fmt.Fprintln(os.Stderr, service+":", line)
+		}
+		return &EnablerRunError{
+			Service:    service,
+			Result:     status.Result,
+			ExitStatus: status.ExecMainStatus,
+			Journal:    journal,
+		}
+	}
+	fmt.Println(service, "ran successfully")
+	return nil
+}
@@ -0,0 +1,239 @@
+// Copyright (c) 2022, SUSE LLC, All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	HEARTBEAT_COMMAND   = "heartbeat"
+	HEARTBEAT_PID_FILE  = "heartbeat.pid"
+	HEARTBEAT_INTERVAL  = 5 * time.Minute
+	HEARTBEAT_VERSION   = 1.0
+	HEARTBEAT_READY     = "ready"
+	HEARTBEAT_NOT_READY = "notready"
+)
+
+// What the enabler timer and the registercloudguest run behind it look like
+type EnablerHealth struct {
+	Timer         string `json:"timer"`
+	TimerActive   bool   `json:"timerActive"`
+	LastTrigger   string `json:"lastTrigger,omitempty"`
+	Service       string `json:"service,omitempty"`
+	LastRunResult string `json:"lastRunResult,omitempty"`
+	LastRunStatus int    `json:"lastRunExitStatus"`
+	LastRunExited string `json:"lastRunExited,omitempty"`
+}
+
+// registercloudguest ran at least once and did not fail
+func (health EnablerHealth) lastRunSucceeded() bool {
+	return health.LastRunResult == "success" && health.LastRunStatus == 0 && health.LastRunExited != ""
+}
+
+func (health EnablerHealth) String() string {
+	message := "Timer " + health.Timer
+	if health.TimerActive {
+		message += " is active"
+	} else {
+		message += " is not active"
+	}
+	if health.LastTrigger == "" {
+		message += ", it never fired"
+	} else {
+		message += ", last fired " + health.LastTrigger
+	}
+	switch {
+	case health.LastRunExited == "":
+		message += ", registercloudguest has not run yet"
+	case health.lastRunSucceeded():
+		message += ", last registercloudguest run succeeded at " + health.LastRunExited
+	default:
+		message += fmt.Sprintf(", last registercloudguest run failed at %s (result %s, exit status %d)",
+			health.LastRunExited, health.LastRunResult, health.LastRunStatus)
+	}
+	return message
+}
+
+type FormattedMessage struct {
+	Lang    string `json:"lang"`
+	Message string `json:"message"`
+}
+
+type Heartbeat struct {
+	Status           string           `json:"status"`
+	Code             int              `json:"code"`
+	FormattedMessage FormattedMessage `json:"formattedMessage"`
+}
+
+type HeartbeatReport struct {
+	Version   float64   `json:"version"`
+	Heartbeat Heartbeat `json:"heartbeat"`
+}
+
+func _getEnablerHealth(manager SystemdManager, timer string) (EnablerHealth, error) {
+	health := EnablerHealth{Timer: timer}
+	timerStatus, err := manager.Status(timer)
+	if err != nil {
+		return health, err
+	}
+	health.TimerActive = timerStatus.IsActive()
+	health.LastTrigger = _formatTimestamp(timerStatus.LastTrigger)
+	health.Service = timerStatus.Unit
+	if health.Service == "" {
+		return health, nil
+	}
+	serviceStatus, err := manager.ServiceStatus(health.Service)
+	if err != nil {
+		return health, err
+	}
+	health.LastRunResult = serviceStatus.Result
+	health.LastRunStatus = serviceStatus.ExecMainStatus
+	health.LastRunExited = _formatTimestamp(serviceStatus.ExecMainExitTimestamp)
+	return health, nil
+}
+
+func _newHeartbeat(health EnablerHealth, err error) Heartbeat {
+	if err != nil {
+		return Heartbeat{
+			Status:           HEARTBEAT_NOT_READY,
+			Code:             1,
+			FormattedMessage: FormattedMessage{Lang: "en-US", Message: "Could not get the state of the timer: " + err.Error()},
+		}
+	}
+	heartbeat := Heartbeat{
+		Status:           HEARTBEAT_READY,
+		FormattedMessage: FormattedMessage{Lang: "en-US", Message: health.String()},
+	}
+	// not having run yet is fine right after enable
+	if !health.TimerActive || (health.LastRunExited != "" && !health.lastRunSucceeded()) {
+		heartbeat.Status = HEARTBEAT_NOT_READY
+		heartbeat.Code = 1
+	}
+	return heartbeat
+}
+
+// Replace the heartbeat file, the guest agent may read it at any time
+func _writeHeartbeat(path string, heartbeat Heartbeat) error {
+	content, err := json.Marshal([]HeartbeatReport{{Version: HEARTBEAT_VERSION, Heartbeat: heartbeat}})
+	if err != nil {
+		return err
+	}
+	tempFile := path + ".tmp"
+	if err = ioutil.WriteFile(tempFile, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tempFile, path)
+}
+
+func _reportHeartbeat(manager SystemdManager, heartbeatFile string, timer string) error {
+	health, err := _getEnablerHealth(manager, timer)
+	return _writeHeartbeat(heartbeatFile, _newHeartbeat(health, err))
+}
+
+func _readHeartbeatPid(dataDir string) int {
+	content, err := ioutil.ReadFile(filepath.Join(dataDir, HEARTBEAT_PID_FILE))
+	if err != nil {
+		return 0
+	}
+	pid, _ := strconv.Atoi(strings.TrimSpace(string(content)))
+	return pid
+}
+
+// The pid may have been reused since the reporter wrote it
+func _isHeartbeatProcess(pid int) bool {
+	cmdline, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil {
+		return false
+	}
+	args := strings.Split(string(cmdline), "\x00")
+	return len(args) > 1 && args[1] == HEARTBEAT_COMMAND
+}
+
+// Start the reporter in the background, it outlives the enable
+// command and keeps the heartbeat file current until disable
+func _startHeartbeat(dataDir string, heartbeatFile string, timer string) error {
+	if heartbeatFile == "" {
+		return fmt.Errorf("No heartbeat file in the handler environment")
+	}
+	_stopHeartbeat(dataDir)
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(executable, HEARTBEAT_COMMAND, dataDir, heartbeatFile, timer)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+	// the reporter writes its pid file itself
+	return cmd.Process.Release()
+}
+
+func _stopHeartbeat(dataDir string) {
+	pid := _readHeartbeatPid(dataDir)
+	if pid > 0 && _isHeartbeatProcess(pid) {
+		if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
+			fmt.Fprintln(os.Stderr, "Error stopping heartbeat reporter:", err)
+		}
+	}
+	if err := os.Remove(filepath.Join(dataDir, HEARTBEAT_PID_FILE)); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintln(os.Stderr, "Error removing heartbeat pid file:", err)
+	}
+}
+
+// The heartbeat command, reports the health of the timer until the
+// pid file no longer names this process. A newer reporter or disable
+// take the pid file over.
+func runHeartbeat(args []string) int {
+	if len(args) != 3 {
+		fmt.Fprintln(os.Stderr, "Usage:", filepath.Base(os.Args[0]), HEARTBEAT_COMMAND, "<data dir> <heartbeat file> <timer>")
+		return 2
+	}
+	dataDir, heartbeatFile, timer := args[0], args[1], args[2]
+	pidFile := filepath.Join(dataDir, HEARTBEAT_PID_FILE)
+	if err := ioutil.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())), 0644); err != nil {
+		fmt.Fprintln(os.Stderr, "Error writing heartbeat pid file:", err)
+		return 1
+	}
+	for {
+		if _readHeartbeatPid(dataDir) != os.Getpid() {
+			return 0
+		}
+		// a new connection every time, the reporter outlives
+		// restarts of systemd and dbus
+		manager := newHost().systemdManager()
+		if err := _reportHeartbeat(manager, heartbeatFile, timer); err != nil {
+			fmt.Fprintln(os.Stderr, "Error writing heartbeat:", err)
+		}
+		manager.Close()
+		time.Sleep(HEARTBEAT_INTERVAL)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2022, SUSE LLC, All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const ZYPP_DIR = "/etc/zypp"
+
+// The VM the extension works on: how commands are run, where the zypp
+// configuration and os-release live and which SCC and systemd to talk
+// to. The callbacks work on newHost(), tests point it at fakes.
+type Host struct {
+	Runner        CommandRunner
+	ZyppDir       string
+	OSReleaseFile string
+	SCCUrl        string
+	// where the progress of the checks is printed
+	Output io.Writer
+	// where the CA certificate of the RMT server goes
+	RMTCaCertificatePath string
+	// called before every wait for the zypp lock
+	OnZyppLockWait func(holder ZyppLockHolder, wait time.Duration)
+	// longest time all zypper calls together wait for the zypp lock
+	ZyppLockMaxWait time.Duration
+	zyppLockWaited  time.Duration
+	connectSystemd  func(host *Host) SystemdManager
+}
+
+func newHost() *Host {
+	host := &Host{
+		Runner:               ExecCommandRunner{},
+		ZyppDir:              ZYPP_DIR,
+		OSReleaseFile:        OS_RELEASE_FILE,
+		SCCUrl:               SCC_DEFAULT_URL,
+		Output:               os.Stdout,
+		RMTCaCertificatePath: RMT_CA_CERTIFICATE_PATH,
+		ZyppLockMaxWait:      ZYPP_LOCK_MAX_WAIT,
+		connectSystemd:       newSystemdManager,
+	}
+	host.OnZyppLockWait = func(holder ZyppLockHolder, wait time.Duration) {
+		fmt.Fprintln(host.Output, _zyppLockWaitMessage(holder, wait))
+	}
+	return host
+}
+
+// D-Bus when the system bus is there, systemctl otherwise. The caller
+// closes it.
+func (host *Host) systemdManager() SystemdManager {
+	return host.connectSystemd(host)
+}
+
+func (host *Host) zyppReposDir() string {
+	return filepath.Join(host.ZyppDir, "repos.d")
+}
+
+func (host *Host) zyppServicesDir() string {
+	return filepath.Join(host.ZyppDir, "services.d")
+}
+
+func (host *Host) zyppCredentialsDir() string {
+	return filepath.Join(host.ZyppDir, "credentials.d")
+}
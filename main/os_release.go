@@ -0,0 +1,172 @@
+// Copyright (c) 2022, SUSE LLC, All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+const OS_RELEASE_FILE = "/etc/os-release"
+
+const (
+	VARIANT_SLES      = "SLES"
+	VARIANT_SLES_SAP  = "SLES_SAP"
+	VARIANT_SLE_MICRO = "SLE-Micro"
+)
+
+// os-release IDs of the SUSE distributions the extension knows about
+var osReleaseVariants = map[string]string{
+	"sles":      VARIANT_SLES,
+	"sles_sap":  VARIANT_SLES_SAP,
+	"sle-micro": VARIANT_SLE_MICRO,
+}
+
+// Go architecture names as used by SUSE products
+var goArchToProductArch = map[string]string{
+	"amd64":   "x86_64",
+	"arm64":   "aarch64",
+	"ppc64le": "ppc64le",
+	"s390x":   "s390x",
+}
+
+type UnsupportedDistributionError struct {
+	ID        string
+	VersionID string
+	Reason    string
+}
+
+func (e *UnsupportedDistributionError) Error() string {
+	return fmt.Sprintf("Unsupported distribution %s %s: %s", e.ID, e.VersionID, e.Reason)
+}
+
+type OSRelease struct {
+	ID         string
+	VersionID  string
+	PrettyName string
+	Variant    string
+	Major      int
+	SP         int
+	Arch       string
+}
+
+// Version used in SCC product triplets: 15.4 for SLE 15, 12 for SLE 12
+func (release OSRelease) ModuleVersion() string {
+	if release.Major == 12 {
+		return "12"
+	}
+	return release.VersionID
+}
+
+// Version used in the unrestricted repo URL, only the major version
+func (release OSRelease) RepoVersion() string {
+	return strconv.Itoa(release.Major)
+}
+
+func (release OSRelease) ProductTriplet(product string) string {
+	return product + "/" + release.ModuleVersion() + "/" + release.Arch
+}
+
+// Read the KEY=value lines of an os-release file, values may be quoted
+func _parseOSReleaseFields(reader io.Reader) (map[string]string, error) {
+	fields := map[string]string{}
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		separator := strings.Index(line, "=")
+		if separator == -1 {
+			continue
+		}
+		key, value := line[:separator], line[separator+1:]
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else {
+			value = strings.Trim(value, `"'`)
+		}
+		fields[key] = value
+	}
+	return fields, scanner.Err()
+}
+
+func _parseOSRelease(reader io.Reader, goArch string) (OSRelease, error) {
+	fields, err := _parseOSReleaseFields(reader)
+	if err != nil {
+		return OSRelease{}, err
+	}
+	release := OSRelease{
+		ID:         fields["ID"],
+		VersionID:  fields["VERSION_ID"],
+		PrettyName: fields["PRETTY_NAME"],
+	}
+	variant, found := osReleaseVariants[release.ID]
+	if !found {
+		return release, &UnsupportedDistributionError{release.ID, release.VersionID, "not a SUSE Linux Enterprise distribution"}
+	}
+	release.Variant = variant
+
+	versionParts := strings.SplitN(release.VersionID, ".", 2)
+	if release.Major, err = strconv.Atoi(versionParts[0]); err != nil {
+		return release, &UnsupportedDistributionError{release.ID, release.VersionID, "invalid VERSION_ID"}
+	}
+	if len(versionParts) == 2 {
+		if release.SP, err = strconv.Atoi(versionParts[1]); err != nil {
+			return release, &UnsupportedDistributionError{release.ID, release.VersionID, "invalid VERSION_ID"}
+		}
+	}
+
+	release.Arch, found = goArchToProductArch[goArch]
+	if !found {
+		return release, &UnsupportedDistributionError{release.ID, release.VersionID, "unsupported architecture " + goArch}
+	}
+	return release, nil
+}
+
+func _getOSRelease(host *Host) (OSRelease, error) {
+	osReleaseFile, err := os.Open(host.OSReleaseFile)
+	if err != nil {
+		return OSRelease{}, err
+	}
+	defer osReleaseFile.Close()
+	return _parseOSRelease(osReleaseFile, runtime.GOARCH)
+}
+
+// The release of a SLES or SLES for SAP the public cloud module is built for
+func _getSLESRelease(host *Host) (OSRelease, error) {
+	release, err := _getOSRelease(host)
+	if err != nil {
+		return release, err
+	}
+	if release.Variant != VARIANT_SLES && release.Variant != VARIANT_SLES_SAP {
+		return release, &UnsupportedDistributionError{release.ID, release.VersionID, "not SLES or SLES for SAP"}
+	}
+	if release.Major != 12 && release.Major != 15 {
+		return release, &UnsupportedDistributionError{release.ID, release.VersionID, "only SLE 12 and SLE 15 are supported"}
+	}
+	return release, nil
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2022, SUSE LLC, All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOSRelease(t *testing.T) {
+	tests := []struct {
+		name      string
+		osRelease string
+		goArch    string
+		expected  OSRelease
+		// not a release the public cloud module is built for
+		notSLES bool
+	}{
+		{
+			name:      "sles 15.4",
+			osRelease: "NAME=\"SLES\"\nVERSION=\"15-SP4\"\nVERSION_ID=\"15.4\"\nPRETTY_NAME=\"SUSE Linux Enterprise Server 15 SP4\"\nID=\"sles\"\n",
+			goArch:    "amd64",
+			expected: OSRelease{ID: "sles", VersionID: "15.4", PrettyName: "SUSE Linux Enterprise Server 15 SP4",
+				Variant: VARIANT_SLES, Major: 15, SP: 4, Arch: "x86_64"},
+		},
+		{
+			name:      "sles 12.5",
+			osRelease: "NAME=\"SLES\"\nVERSION=\"12-SP5\"\nVERSION_ID=\"12.5\"\nID=\"sles\"\n",
+			goArch:    "arm64",
+			expected:  OSRelease{ID: "sles", VersionID: "12.5", Variant: VARIANT_SLES, Major: 12, SP: 5, Arch: "aarch64"},
+		},
+		{
+			name:      "sles_sap unquoted",
+			osRelease: "# SLES for SAP\nID=sles_sap\nVERSION_ID='15.3'\n",
+			goArch:    "amd64",
+			expected:  OSRelease{ID: "sles_sap", VersionID: "15.3", Variant: VARIANT_SLES_SAP, Major: 15, SP: 3, Arch: "x86_64"},
+		},
+		{
+			name:      "sle-micro",
+			osRelease: "ID=\"sle-micro\"\nVERSION_ID=\"5.4\"\n",
+			goArch:    "amd64",
+			expected:  OSRelease{ID: "sle-micro", VersionID: "5.4", Variant: VARIANT_SLE_MICRO, Major: 5, SP: 4, Arch: "x86_64"},
+			notSLES:   true,
+		},
+		{
+			name:      "sles 16.0",
+			osRelease: "ID=\"sles\"\nVERSION_ID=\"16.0\"\n",
+			goArch:    "amd64",
+			expected:  OSRelease{ID: "sles", VersionID: "16.0", Variant: VARIANT_SLES, Major: 16, SP: 0, Arch: "x86_64"},
+			notSLES:   true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			release, err := _parseOSRelease(strings.NewReader(test.osRelease), test.goArch)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, release)
+
+			host := newTestHost(t, &ScriptedCommandRunner{})
+			_writeTestFile(t, host.OSReleaseFile, test.osRelease, 0644)
+			_, err = _getSLESRelease(host)
+			var unsupported *UnsupportedDistributionError
+			assert.Equal(t, test.notSLES, errors.As(err, &unsupported), "%v", err)
+		})
+	}
+}
+
+func TestParseOSReleaseUnsupported(t *testing.T) {
+	tests := []struct {
+		name      string
+		osRelease string
+		goArch    string
+		reason    string
+	}{
+		{"opensuse-leap", "ID=\"opensuse-leap\"\nVERSION_ID=\"15.4\"\n", "amd64", "not a SUSE Linux Enterprise distribution"},
+		{"malformed VERSION_ID", "ID=\"sles\"\nVERSION_ID=\"15-SP4\"\n", "amd64", "invalid VERSION_ID"},
+		{"malformed service pack", "ID=\"sles\"\nVERSION_ID=\"15.SP4\"\n", "amd64", "invalid VERSION_ID"},
+		{"no VERSION_ID", "ID=\"sles\"\n", "amd64", "invalid VERSION_ID"},
+		{"unsupported arch", "ID=\"sles\"\nVERSION_ID=\"15.4\"\n", "386", "unsupported architecture 386"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := _parseOSRelease(strings.NewReader(test.osRelease), test.goArch)
+			var unsupported *UnsupportedDistributionError
+			require.True(t, errors.As(err, &unsupported), "%v", err)
+			assert.Equal(t, test.reason, unsupported.Reason)
+		})
+	}
+}
+
+func TestOSReleaseVersions(t *testing.T) {
+	sles15 := OSRelease{VersionID: "15.4", Major: 15, SP: 4, Arch: "x86_64"}
+	assert.Equal(t, "sle-module-public-cloud/15.4/x86_64", sles15.ProductTriplet("sle-module-public-cloud"))
+	assert.Equal(t, "15", sles15.RepoVersion())
+	sles12 := OSRelease{VersionID: "12.5", Major: 12, SP: 5, Arch: "x86_64"}
+	assert.Equal(t, "sle-module-public-cloud/12/x86_64", sles12.ProductTriplet("sle-module-public-cloud"))
+	assert.Equal(t, "12", sles12.RepoVersion())
+}
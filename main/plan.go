@@ -0,0 +1,311 @@
+// Copyright (c) 2022, SUSE LLC, All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/Azure/azure-extension-platform/vmextension"
+)
+
+const (
+	ACTION_INSTALL_CA_CERTIFICATE = "install-ca-certificate"
+	ACTION_REACTIVATE_SERVICES    = "reactivate-services"
+	ACTION_ACTIVATE_MODULE        = "activate-module"
+	ACTION_REMOVE_STALE_REPOS     = "remove-stale-repos"
+	ACTION_ADD_REPO               = "add-repo"
+	ACTION_INSTALL_PACKAGES       = "install-packages"
+	ACTION_REMOVE_REPO            = "remove-repo"
+)
+
+// One step of the install
+type InstallAction struct {
+	Kind        string   `json:"kind"`
+	Description string   `json:"description"`
+	Args        []string `json:"args,omitempty"`
+	run         func(host *Host, state *StateStore) error
+	// a failure is logged and the install goes on
+	bestEffort bool
+}
+
+// Ordered list of actions computed from the detected state of the VM
+type InstallPlan struct {
+	RegistrationMode string          `json:"registrationMode,omitempty"`
+	Actions          []InstallAction `json:"actions"`
+}
+
+func (plan *InstallPlan) add(action InstallAction) {
+	plan.Actions = append(plan.Actions, action)
+}
+
+func (plan *InstallPlan) String() string {
+	content, err := json.Marshal(plan)
+	if err != nil {
+		return err.Error()
+	}
+	return string(content)
+}
+
+// Nothing to do when registercloudguest, the addon and the right
+// versions of all the packages are already there
+func _isInstallNeeded(host *Host, ahbInfo AHBInfo) bool {
+	if _, err := os.Stat(ahbInfo.RegisterCloudGuestPath); err != nil {
+		return true
+	}
+	if _, err := os.Stat(ahbInfo.AddonPath); err != nil {
+		return true
+	}
+	return !_checkVersion(host, ahbInfo)
+}
+
+// Registration of the VM as SUSEConnect and SCC see it, Mode is one of
+// the REGISTRATION_* values recorded in the state
+type RegistrationStatus struct {
+	Registered         bool
+	ActiveSubscription bool
+	CredentialsMissing bool
+	Mode               string
+}
+
+func _detectRegistration(host *Host, settings PublicSettings, rmt *RMTSettings) (RegistrationStatus, error) {
+	registration := RegistrationStatus{}
+	isRegistered, hasActiveSubscription, err := _getSUSEConnectStatus(host, rmt, settings.CredentialsPath)
+
+	var missingCredentials *MissingCredentialsError
+	registration.CredentialsMissing = errors.As(err, &missingCredentials)
+	if registration.CredentialsMissing {
+		// without credentials the subscription can't be checked,
+		// use the unrestricted repo and leave the repos alone
+		fmt.Println("System is registered but", err, "- using the unrestricted repository")
+		err = nil
+	}
+	if err != nil {
+		return registration, err
+	}
+	registration.Registered = isRegistered
+	registration.ActiveSubscription = hasActiveSubscription
+
+	switch {
+	case isRegistered && rmt != nil:
+		registration.Mode = REGISTRATION_RMT
+	case isRegistered && hasActiveSubscription:
+		registration.Mode = REGISTRATION_REGISTERED
+	case isRegistered && registration.CredentialsMissing:
+		registration.Mode = REGISTRATION_UNVERIFIED
+	case isRegistered:
+		registration.Mode = REGISTRATION_SUBSCRIPTION_EXPIRED
+	default:
+		registration.Mode = REGISTRATION_UNREGISTERED
+	}
+	return registration, nil
+}
+
+func _planInstall(host *Host, settings PublicSettings, rmt *RMTSettings) (*InstallPlan, error) {
+	if !_isInstallNeeded(host, settings.AHBInfo) {
+		plan := &InstallPlan{Actions: []InstallAction{}}
+		// nothing to install, the mode still goes into the state
+		// for the enable status
+		registration, err := _detectRegistration(host, settings, rmt)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Could not detect the registration mode:", err)
+			return plan, nil
+		}
+		plan.RegistrationMode = registration.Mode
+		return plan, nil
+	}
+	return _planPackageInstall(host, settings, rmt)
+}
+
+// Detect the registration state of the VM and work out what needs to
+// happen to get the AHB packages installed. Nothing is changed here.
+func _planPackageInstall(host *Host, settings PublicSettings, rmt *RMTSettings) (*InstallPlan, error) {
+	ahbInfo := settings.AHBInfo
+	plan := &InstallPlan{Actions: []InstallAction{}}
+	if rmt != nil && rmt.CaCertificate != "" {
+		plan.add(InstallAction{
+			Kind:        ACTION_INSTALL_CA_CERTIFICATE,
+			Description: "Install RMT CA certificate",
+			Args:        []string{host.RMTCaCertificatePath},
+			run: func(host *Host, state *StateStore) error {
+				return _installRMTCaCertificate(host, rmt, state)
+			},
+		})
+	}
+
+	registration, err := _detectRegistration(host, settings, rmt)
+	if err != nil {
+		return nil, err
+	}
+	plan.RegistrationMode = registration.Mode
+	isRegistered := registration.Registered
+	hasActiveSubscription := registration.ActiveSubscription
+	credentialsMissing := registration.CredentialsMissing
+
+	repoUrl, err := _getUnrestrictedRepoUrl(host, ahbInfo.RepoUrl, rmt)
+	if err != nil {
+		return nil, err
+	}
+	installPackages := InstallAction{
+		Kind:        ACTION_INSTALL_PACKAGES,
+		Description: "Install packages",
+		Args:        _getAhbPackageNames(ahbInfo),
+		run: func(host *Host, state *StateStore) error {
+			// install cloud-regionsrv-client and addon packages
+			return _installPackages(host, ahbInfo, state)
+		},
+	}
+	removeRepo := InstallAction{
+		Kind:        ACTION_REMOVE_REPO,
+		Description: "Remove repo",
+		Args:        []string{ahbInfo.RepoAlias},
+		// the packages are installed by now, a leftover
+		// repo is not worth undoing them for, it stays
+		// in the state and uninstall removes it
+		bestEffort: true,
+		run: func(host *Host, state *StateStore) error {
+			// only if the extension added it
+			for _, repoAlias := range state.State.AddedRepos {
+				if repoAlias == ahbInfo.RepoAlias {
+					return _removeRepo(host, ahbInfo.RepoAlias, state)
+				}
+			}
+			return nil
+		},
+	}
+
+	if isRegistered && hasActiveSubscription {
+		// system is registered with an active subscription
+		// check if services are present
+		hasServices, err := _hasServices(host)
+		if err != nil {
+			return nil, err
+		}
+		if !hasServices {
+			plan.add(InstallAction{
+				Kind:        ACTION_REACTIVATE_SERVICES,
+				Description: "Reactivate services",
+				run: func(host *Host, state *StateStore) error {
+					return _reactivateServices(host, rmt)
+				},
+			})
+		}
+		hasPubCloudMod, err := _hasPubCloudMod(host, ahbInfo.PublicCloudService)
+		if err != nil {
+			return nil, err
+		}
+		if !hasPubCloudMod {
+			release, err := _getSLESRelease(host)
+			if err != nil {
+				return nil, err
+			}
+			triplet := release.ProductTriplet(ahbInfo.ModName)
+			plan.add(InstallAction{
+				Kind:        ACTION_ACTIVATE_MODULE,
+				Description: "Activate public cloud module",
+				Args:        []string{triplet, ahbInfo.RepoAlias, repoUrl},
+				run: func(host *Host, state *StateStore) error {
+					return _activatePubCloudModule(host, triplet, ahbInfo.RepoAlias, repoUrl, rmt, state)
+				},
+			})
+		}
+		plan.add(installPackages)
+		if !hasPubCloudMod {
+			// packages installed, remove repo
+			plan.add(removeRepo)
+		}
+		return plan, nil
+	}
+
+	if isRegistered && !hasActiveSubscription && !credentialsMissing {
+		plan.add(InstallAction{
+			Kind:        ACTION_REMOVE_STALE_REPOS,
+			Description: "Remove repositories of the expired subscription",
+			run: func(host *Host, state *StateStore) error {
+				return _removeRepositories(host, state)
+			},
+		})
+	}
+	plan.add(InstallAction{
+		Kind:        ACTION_ADD_REPO,
+		Description: "Add unrestricted repo",
+		Args:        []string{ahbInfo.RepoAlias, repoUrl},
+		run: func(host *Host, state *StateStore) error {
+			return _addRepo(host, ahbInfo.RepoAlias, repoUrl, state)
+		},
+	})
+	plan.add(installPackages)
+	// packages installed, remove repo
+	plan.add(removeRepo)
+	return plan, nil
+}
+
+// Run the actions of the plan in order. Every action registers what it
+// changed, when one fails everything done so far, including the partial
+// changes of the failed action, is undone in reverse order. Failures of
+// best effort actions are only logged.
+func _executeInstallPlan(host *Host, plan *InstallPlan, events EventLogger, state *StateStore) error {
+	if plan.RegistrationMode != "" {
+		if err := state.registrationMode(plan.RegistrationMode); err != nil {
+			return err
+		}
+	}
+	transaction := &installTransaction{}
+	for _, action := range plan.Actions {
+		fmt.Println("Running install action:", action.Description, action.Args)
+		before := state.State.copy()
+		err := action.run(host, state)
+		transaction.register(action.Description, _stateChanges(before, state.State))
+		if err != nil && action.bestEffort {
+			fmt.Fprintln(os.Stderr, "Error in install action", action.Description+", continuing:", err)
+			events.LogWarningEvent(
+				INSTALL_EVENT,
+				fmt.Sprintf(OPERATION_FAILURE_MSG, action.Description, err.Error()))
+			continue
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error in install action", action.Description+":", err)
+			events.LogErrorEvent(
+				INSTALL_EVENT,
+				fmt.Sprintf(OPERATION_FAILURE_MSG, action.Description, err.Error()))
+			transaction.rollback(host, events, state)
+			return err
+		}
+	}
+	return nil
+}
+
+func _handlePackageInstall(host *Host, settings PublicSettings, rmt *RMTSettings, events EventLogger, state *StateStore) error {
+	plan, err := _planPackageInstall(host, settings, rmt)
+	if err != nil {
+		return err
+	}
+	return _executeInstallPlan(host, plan, events, state)
+}
+
+// Report the plan instead of running it
+func _reportDryRun(plan *InstallPlan, ext *vmextension.VMExtension) {
+	message := "Dry run, planned install actions: " + plan.String()
+	fmt.Println(message)
+	ext.ExtensionEvents.LogInformationalEvent(INSTALL_EVENT, message)
+}
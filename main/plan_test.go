@@ -0,0 +1,275 @@
+// Copyright (c) 2022, SUSE LLC, All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testSLESService = `[SUSE_Linux_Enterprise_Server_x86_64]
+name=SUSE_Linux_Enterprise_Server_x86_64
+enabled=1
+autorefresh=1
+url=https://smt-azure.susecloud.net/services/2045/repo/repoindex.xml?credentials=SUSE_Linux_Enterprise_Server_x86_64
+type=ris
+`
+	testPublicCloudService = `[Public_Cloud_Module_x86_64]
+name=Public_Cloud_Module_x86_64
+enabled=1
+autorefresh=1
+url=https://rmt.example.com/services/1960/repo/repoindex.xml?credentials=Public_Cloud_Module_x86_64
+type=ris
+`
+	testSusecloudRepo = `[SLE-Module-Basesystem15-SP4-Updates]
+name=SLE-Module-Basesystem15-SP4-Updates
+enabled=1
+autorefresh=1
+baseurl=plugin:/susecloud?credentials=Basesystem_Module_x86_64&path=/repo/SUSE/Updates/SLE-Module-Basesystem/15-SP4/x86_64/update/
+type=rpm-md
+`
+)
+
+// Events logged through the extension, by level
+type recordingEventLogger struct {
+	informational []string
+	warnings      []string
+	errors        []string
+}
+
+func (events *recordingEventLogger) LogInformationalEvent(taskName string, message string) {
+	events.informational = append(events.informational, taskName+": "+message)
+}
+
+func (events *recordingEventLogger) LogWarningEvent(taskName string, message string) {
+	events.warnings = append(events.warnings, taskName+": "+message)
+}
+
+func (events *recordingEventLogger) LogErrorEvent(taskName string, message string) {
+	events.errors = append(events.errors, taskName+": "+message)
+}
+
+// SCC reporting a single subscription in the given status, the host
+// talks to it with the system credentials
+func newTestSCCServer(t *testing.T, host *Host, status string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/connect/systems/subscriptions", r.URL.Path)
+		w.Write([]byte(strings.Replace(sccSubscriptionsResponse, "ACTIVE", status, 1)))
+	}))
+	t.Cleanup(server.Close)
+	host.SCCUrl = server.URL
+	_writeTestFile(t, filepath.Join(host.zyppCredentialsDir(), SCC_CREDENTIALS_FILE),
+		"username=SCC_user\npassword=secret\n", 0600)
+	return server
+}
+
+// AHB info of the extension with registercloudguest and the addon
+// missing, so that the install is needed
+func newTestAhbInfo(t *testing.T) AHBInfo {
+	dir := t.TempDir()
+	ahbInfo := getAhbInfo()
+	ahbInfo.RegisterCloudGuestPath = filepath.Join(dir, "registercloudguest")
+	ahbInfo.AddonPath = filepath.Join(dir, "regionsrv-enabler-azure")
+	return ahbInfo
+}
+
+// Plan and run the install on the host, the way the install callback does
+func runTestInstall(t *testing.T, host *Host, ahbInfo AHBInfo, rmt *RMTSettings) (*InstallPlan, *StateStore, *recordingEventLogger, error) {
+	state, err := _loadState(t.TempDir())
+	require.NoError(t, err)
+	plan, err := _planInstall(host, PublicSettings{AHBInfo: ahbInfo}, rmt)
+	require.NoError(t, err)
+	events := &recordingEventLogger{}
+	return plan, state, events, _executeInstallPlan(host, plan, events, state)
+}
+
+func _actionKinds(plan *InstallPlan) []string {
+	kinds := []string{}
+	for _, action := range plan.Actions {
+		kinds = append(kinds, action.Kind)
+	}
+	return kinds
+}
+
+// Unrestricted repo URL and public cloud module triplet for the
+// architecture the tests run on
+func _testRepoUrlAndTriplet(t *testing.T, host *Host, ahbInfo AHBInfo) (string, string) {
+	release, err := _getSLESRelease(host)
+	require.NoError(t, err)
+	return fmt.Sprintf(ahbInfo.RepoUrl, release.RepoVersion(), release.Arch), release.ProductTriplet(ahbInfo.ModName)
+}
+
+// The enable status after a successful install, from the recorded rpm
+// and systemctl output at the end of the script
+func assertInstalledEnableStatus(t *testing.T, host *Host, ahbInfo AHBInfo, state *StateStore, registration string) {
+	status := _getEnableStatus(host, "success", ahbInfo, state, host.systemdManager())
+	assert.Equal(t, state.State.RegistrationMode, status.RegistrationMode)
+	assert.Equal(t, "10.1.0-150000.6.93.1", status.Packages[ahbInfo.RegionSrv])
+	assert.True(t, status.Timer.Enabled)
+	assert.Equal(t, "2022-08-01T11:00:00Z", status.Timer.NextTrigger)
+	results := map[string]string{}
+	for _, substatus := range status.Substatus {
+		results[substatus.Name] = substatus.Status
+	}
+	assert.Equal(t, map[string]string{
+		"registration": registration,
+		"packages":     SUBSTATUS_SUCCESS,
+		"timer":        SUBSTATUS_SUCCESS,
+	}, results)
+}
+
+func TestInstallRegistered(t *testing.T) {
+	runner := loadScriptedCommandRunner(t, "install_registered")
+	host := newTestHost(t, runner)
+	newTestSCCServer(t, host, "ACTIVE")
+	_writeTestFile(t, filepath.Join(host.zyppServicesDir(), "SUSE_Linux_Enterprise_Server_x86_64.service"), testSLESService, 0644)
+	ahbInfo := newTestAhbInfo(t)
+	_, triplet := _testRepoUrlAndTriplet(t, host, ahbInfo)
+
+	plan, state, events, err := runTestInstall(t, host, ahbInfo, nil)
+	require.NoError(t, err)
+	assert.Equal(t, REGISTRATION_REGISTERED, plan.RegistrationMode)
+	assert.Equal(t, []string{ACTION_ACTIVATE_MODULE, ACTION_INSTALL_PACKAGES, ACTION_REMOVE_REPO}, _actionKinds(plan))
+	assert.Equal(t, "SUSEConnect -p "+triplet, runner.Calls[1])
+	assert.Equal(t, []string{triplet}, state.State.ActivatedModules)
+	assert.Empty(t, state.State.AddedRepos)
+	assert.ElementsMatch(t, _getAhbPackageNames(ahbInfo), state.State.InstalledPackages)
+	assert.Empty(t, events.warnings)
+	assert.Empty(t, events.errors)
+
+	assertInstalledEnableStatus(t, host, ahbInfo, state, SUBSTATUS_SUCCESS)
+	assert.True(t, runner.Done(), "%v", runner.Calls)
+}
+
+func TestInstallAlreadyInstalledRecordsRegistrationMode(t *testing.T) {
+	runner := loadScriptedCommandRunner(t, "install_already_installed")
+	host := newTestHost(t, runner)
+	newTestSCCServer(t, host, "ACTIVE")
+	ahbInfo := newTestAhbInfo(t)
+	// the image ships registercloudguest and the addon
+	_writeTestFile(t, ahbInfo.RegisterCloudGuestPath, "", 0755)
+	_writeTestFile(t, ahbInfo.AddonPath, "", 0755)
+
+	plan, state, events, err := runTestInstall(t, host, ahbInfo, nil)
+	require.NoError(t, err)
+	assert.Empty(t, plan.Actions)
+	assert.Equal(t, REGISTRATION_REGISTERED, plan.RegistrationMode)
+	assert.Equal(t, REGISTRATION_REGISTERED, state.State.RegistrationMode)
+	assert.Empty(t, state.State.InstalledPackages)
+	assert.Empty(t, events.errors)
+
+	assertInstalledEnableStatus(t, host, ahbInfo, state, SUBSTATUS_SUCCESS)
+	assert.True(t, runner.Done(), "%v", runner.Calls)
+}
+
+func TestInstallSubscriptionExpired(t *testing.T) {
+	runner := loadScriptedCommandRunner(t, "install_expired")
+	host := newTestHost(t, runner)
+	newTestSCCServer(t, host, "EXPIRED")
+	_writeTestFile(t, filepath.Join(host.zyppServicesDir(), "SUSE_Linux_Enterprise_Server_x86_64.service"), testSLESService, 0644)
+	staleRepo := filepath.Join(host.zyppReposDir(), "SLE-Module-Basesystem15-SP4-Updates.repo")
+	_writeTestFile(t, staleRepo, testSusecloudRepo, 0644)
+	otherRepo := filepath.Join(host.zyppReposDir(), "custom.repo")
+	_writeTestFile(t, otherRepo, "[custom]\nbaseurl=https://example.com/repo\n", 0644)
+	ahbInfo := newTestAhbInfo(t)
+	repoUrl, _ := _testRepoUrlAndTriplet(t, host, ahbInfo)
+
+	plan, state, events, err := runTestInstall(t, host, ahbInfo, nil)
+	require.NoError(t, err)
+	assert.Equal(t, REGISTRATION_SUBSCRIPTION_EXPIRED, plan.RegistrationMode)
+	assert.Equal(t, []string{ACTION_REMOVE_STALE_REPOS, ACTION_ADD_REPO, ACTION_INSTALL_PACKAGES, ACTION_REMOVE_REPO}, _actionKinds(plan))
+	assert.Equal(t, "zypper --xmlout --non-interactive addrepo "+repoUrl+" "+ahbInfo.RepoAlias, runner.Calls[1])
+	assert.NoFileExists(t, staleRepo)
+	assert.FileExists(t, otherRepo)
+	require.Len(t, state.State.RemovedRepos, 1)
+	assert.Equal(t, staleRepo, state.State.RemovedRepos[0].Path)
+	assert.Empty(t, state.State.AddedRepos)
+	assert.Empty(t, events.errors)
+
+	assertInstalledEnableStatus(t, host, ahbInfo, state, SUBSTATUS_WARNING)
+	assert.True(t, runner.Done(), "%v", runner.Calls)
+}
+
+func TestInstallUnregistered(t *testing.T) {
+	runner := loadScriptedCommandRunner(t, "install_unregistered")
+	host := newTestHost(t, runner)
+	ahbInfo := newTestAhbInfo(t)
+	repoUrl, _ := _testRepoUrlAndTriplet(t, host, ahbInfo)
+
+	plan, state, events, err := runTestInstall(t, host, ahbInfo, nil)
+	require.NoError(t, err)
+	assert.Equal(t, REGISTRATION_UNREGISTERED, plan.RegistrationMode)
+	assert.Equal(t, []string{ACTION_ADD_REPO, ACTION_INSTALL_PACKAGES, ACTION_REMOVE_REPO}, _actionKinds(plan))
+	assert.Equal(t, "zypper --xmlout --non-interactive addrepo "+repoUrl+" "+ahbInfo.RepoAlias, runner.Calls[1])
+	assert.Empty(t, state.State.AddedRepos)
+	assert.Empty(t, state.State.ActivatedModules)
+	assert.ElementsMatch(t, _getAhbPackageNames(ahbInfo), state.State.InstalledPackages)
+	assert.Empty(t, events.errors)
+
+	assertInstalledEnableStatus(t, host, ahbInfo, state, SUBSTATUS_SUCCESS)
+	assert.True(t, runner.Done(), "%v", runner.Calls)
+}
+
+func TestInstallUnregisteredRollsBackFailedInstall(t *testing.T) {
+	runner := loadScriptedCommandRunner(t, "install_unregistered_failure")
+	host := newTestHost(t, runner)
+	ahbInfo := newTestAhbInfo(t)
+
+	_, state, events, err := runTestInstall(t, host, ahbInfo, nil)
+	var zypperError *ZypperError
+	require.True(t, errors.As(err, &zypperError), "%v", err)
+	assert.True(t, errors.Is(err, ErrZypperCommit))
+	// the repo added for the install is removed again
+	assert.Equal(t, "zypper --xmlout --non-interactive removerepo "+ahbInfo.RepoAlias, runner.Calls[len(runner.Calls)-1])
+	assert.Empty(t, state.State.AddedRepos)
+	assert.Empty(t, state.State.InstalledPackages)
+	assert.Len(t, events.errors, 1)
+	assert.True(t, runner.Done(), "%v", runner.Calls)
+}
+
+func TestInstallRMT(t *testing.T) {
+	runner := loadScriptedCommandRunner(t, "install_rmt")
+	host := newTestHost(t, runner)
+	_writeTestFile(t, filepath.Join(host.zyppServicesDir(), "Public_Cloud_Module_x86_64.service"), testPublicCloudService, 0644)
+	ahbInfo := newTestAhbInfo(t)
+	rmt := &RMTSettings{ServerUrl: "https://rmt.example.com"}
+
+	// the subscription is not checked with SCC, the test host can't reach it
+	plan, state, events, err := runTestInstall(t, host, ahbInfo, rmt)
+	require.NoError(t, err)
+	assert.Equal(t, REGISTRATION_RMT, plan.RegistrationMode)
+	assert.Equal(t, []string{ACTION_INSTALL_PACKAGES}, _actionKinds(plan))
+	assert.Empty(t, state.State.AddedRepos)
+	assert.Empty(t, state.State.ActivatedModules)
+	assert.Empty(t, events.errors)
+
+	assertInstalledEnableStatus(t, host, ahbInfo, state, SUBSTATUS_SUCCESS)
+	assert.True(t, runner.Done(), "%v", runner.Calls)
+}
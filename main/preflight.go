@@ -0,0 +1,76 @@
+// Copyright (c) 2022, SUSE LLC, All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+const SYSTEMD_RUNTIME_DIR = "/run/systemd/system"
+
+var (
+	preflightArchs    = []string{"x86_64", "aarch64"}
+	preflightCommands = []string{"zypper", "SUSEConnect", "rpm", "systemctl"}
+)
+
+// A failed compatibility check, the message is meant for the user
+type PreflightError struct {
+	Check   string
+	Message string
+}
+
+func (e *PreflightError) Error() string {
+	return fmt.Sprintf("This VM is not supported by the %s extension: %s", extensionName, e.Message)
+}
+
+// Make sure the VM is something the extension can work on before
+// touching anything
+func _runPreflightChecks(host *Host) error {
+	release, err := _getSLESRelease(host)
+	if err != nil {
+		return &PreflightError{Check: "distribution", Message: err.Error()}
+	}
+	supportedArch := false
+	for _, arch := range preflightArchs {
+		supportedArch = supportedArch || release.Arch == arch
+	}
+	if !supportedArch {
+		return &PreflightError{
+			Check:   "architecture",
+			Message: fmt.Sprintf("architecture %s is not supported, only x86_64 and aarch64 are", release.Arch),
+		}
+	}
+	for _, command := range preflightCommands {
+		if _, err = exec.LookPath(command); err != nil {
+			return &PreflightError{
+				Check:   "commands",
+				Message: fmt.Sprintf("%s is not installed", command),
+			}
+		}
+	}
+	if _, err = os.Stat(SYSTEMD_RUNTIME_DIR); err != nil {
+		return &PreflightError{Check: "systemd", Message: "the system is not running systemd"}
+	}
+	fmt.Fprintln(host.Output, "Preflight checks passed for", release.PrettyName, release.Arch)
+	return nil
+}
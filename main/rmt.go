@@ -0,0 +1,136 @@
+// Copyright (c) 2022, SUSE LLC, All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-extension-platform/vmextension"
+)
+
+const RMT_CA_CERTIFICATE_PATH = "/etc/pki/trust/anchors/ahb-extension-rmt-server.pem"
+
+// Private mirror (RMT or SMT) used instead of SCC and updates.suse.com,
+// read from the protected settings
+type RMTSettings struct {
+	ServerUrl     string `json:"rmtServerUrl"`
+	CaCertificate string `json:"rmtCaCertificate"`
+}
+
+// Returns nil when no RMT server is configured
+func _parseRMTSettings(protectedSettings string) (*RMTSettings, error) {
+	rmt := &RMTSettings{}
+	protectedSettings = strings.TrimSpace(protectedSettings)
+	if protectedSettings == "" || protectedSettings == "null" {
+		return nil, nil
+	}
+	// protected settings may carry other secrets, so unknown
+	// fields are fine here
+	if err := json.Unmarshal([]byte(protectedSettings), rmt); err != nil {
+		return nil, fmt.Errorf("Invalid protected settings: %v", err)
+	}
+	if rmt.ServerUrl == "" {
+		if rmt.CaCertificate != "" {
+			return nil, errors.New("Invalid setting 'rmtCaCertificate': 'rmtServerUrl' is not set")
+		}
+		return nil, nil
+	}
+	serverUrl, err := url.Parse(rmt.ServerUrl)
+	if err != nil || serverUrl.Scheme != "https" || serverUrl.Host == "" {
+		return nil, fmt.Errorf("Invalid setting 'rmtServerUrl': '%s' is not an https URL", rmt.ServerUrl)
+	}
+	rmt.ServerUrl = strings.TrimSuffix(rmt.ServerUrl, "/")
+	if rmt.CaCertificate != "" {
+		block, _ := pem.Decode([]byte(rmt.CaCertificate))
+		if block == nil || block.Type != "CERTIFICATE" {
+			return nil, errors.New("Invalid setting 'rmtCaCertificate': not a PEM encoded certificate")
+		}
+		if _, err = x509.ParseCertificate(block.Bytes); err != nil {
+			return nil, fmt.Errorf("Invalid setting 'rmtCaCertificate': %v", err)
+		}
+	}
+	return rmt, nil
+}
+
+func getRMTSettings(ext *vmextension.VMExtension) (*RMTSettings, error) {
+	extensionSettings, err := ext.GetSettings()
+	if err != nil {
+		return nil, fmt.Errorf("Could not read extension settings: %v", err)
+	}
+	return _parseRMTSettings(extensionSettings.ProtectedSettings)
+}
+
+// Add the CA certificate of the RMT server to the system trust store,
+// SUSEConnect and zypper both use it
+func _installRMTCaCertificate(host *Host, rmt *RMTSettings, state *StateStore) error {
+	if rmt.CaCertificate == "" {
+		return nil
+	}
+	path := host.RMTCaCertificatePath
+	current, err := ioutil.ReadFile(path)
+	if err == nil && string(current) == rmt.CaCertificate {
+		return nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	created := false
+	for _, certificate := range state.State.Certificates {
+		created = created || certificate == path
+	}
+	if err == nil && !created {
+		// uninstall removes the certificates it installed, the one
+		// that is there would be lost
+		return fmt.Errorf("Refusing to overwrite %s, it was not installed by the extension", path)
+	}
+	if err = ioutil.WriteFile(path, []byte(rmt.CaCertificate), 0644); err != nil {
+		fmt.Fprintln(os.Stderr, "Error writing RMT CA certificate", path)
+		return err
+	}
+	if err = state.certificateInstalled(path); err != nil {
+		return err
+	}
+	_, err = host.RunShellCommand(0, "update-ca-certificates")
+	return err
+}
+
+// RMT mirrors the SUSE update repositories below /repo, keep the path of
+// the repo URL template and swap the host
+func _getRMTRepoUrl(rmt *RMTSettings, ahbRepoUrl string) string {
+	path := ahbRepoUrl
+	if start := strings.Index(path, "://"); start != -1 {
+		path = path[start+len("://"):]
+		if end := strings.Index(path, "/"); end != -1 {
+			path = path[end:]
+		} else {
+			path = ""
+		}
+	}
+	return rmt.ServerUrl + "/repo" + path
+}
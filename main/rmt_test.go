@@ -0,0 +1,74 @@
+// Copyright (c) 2022, SUSE LLC, All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testRMTCertificate   = "-----BEGIN CERTIFICATE-----\nrmt\n-----END CERTIFICATE-----\n"
+	testOtherCertificate = "-----BEGIN CERTIFICATE-----\nother\n-----END CERTIFICATE-----\n"
+)
+
+func _updateCaCertificatesCommand() ScriptedCommand {
+	return ScriptedCommand{Name: "update-ca-certificates", Args: []string{}}
+}
+
+func TestInstallRMTCaCertificate(t *testing.T) {
+	runner := &ScriptedCommandRunner{Commands: []ScriptedCommand{_updateCaCertificatesCommand(), _updateCaCertificatesCommand()}}
+	host := newTestHost(t, runner)
+	state, err := _loadState(t.TempDir())
+	require.NoError(t, err)
+
+	require.NoError(t, _installRMTCaCertificate(host, &RMTSettings{CaCertificate: testRMTCertificate}, state))
+	assert.Equal(t, []string{host.RMTCaCertificatePath}, state.State.Certificates)
+
+	// a certificate the extension installed is replaced
+	require.NoError(t, _installRMTCaCertificate(host, &RMTSettings{CaCertificate: testOtherCertificate}, state))
+	content, err := ioutil.ReadFile(host.RMTCaCertificatePath)
+	require.NoError(t, err)
+	assert.Equal(t, testOtherCertificate, string(content))
+	assert.True(t, runner.Done(), "%v", runner.Calls)
+}
+
+func TestInstallRMTCaCertificateKeepsForeignCertificate(t *testing.T) {
+	runner := &ScriptedCommandRunner{}
+	host := newTestHost(t, runner)
+	_writeTestFile(t, host.RMTCaCertificatePath, testOtherCertificate, 0644)
+	state, err := _loadState(t.TempDir())
+	require.NoError(t, err)
+
+	assert.Error(t, _installRMTCaCertificate(host, &RMTSettings{CaCertificate: testRMTCertificate}, state))
+	content, err := ioutil.ReadFile(host.RMTCaCertificatePath)
+	require.NoError(t, err)
+	assert.Equal(t, testOtherCertificate, string(content))
+	assert.Empty(t, state.State.Certificates)
+
+	// the same certificate is fine, it is not taken over either
+	require.NoError(t, _installRMTCaCertificate(host, &RMTSettings{CaCertificate: testOtherCertificate}, state))
+	assert.Empty(t, state.State.Certificates)
+	assert.Empty(t, runner.Calls)
+}
@@ -0,0 +1,229 @@
+// Copyright (c) 2022, SUSE LLC, All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Where the install steps and their rollback report to, the events of
+// the vmextension
+type EventLogger interface {
+	LogInformationalEvent(taskName string, message string)
+	LogWarningEvent(taskName string, message string)
+	LogErrorEvent(taskName string, message string)
+}
+
+func _copyStrings(list []string) []string {
+	return append([]string{}, list...)
+}
+
+func (state ExtensionState) copy() ExtensionState {
+	result := state
+	result.AddedRepos = _copyStrings(state.AddedRepos)
+	result.ActivatedModules = _copyStrings(state.ActivatedModules)
+	result.InstalledPackages = _copyStrings(state.InstalledPackages)
+	result.Certificates = _copyStrings(state.Certificates)
+	result.RemovedRepos = append([]RemovedRepo{}, state.RemovedRepos...)
+	result.PackageVersions = map[string]string{}
+	for name, version := range state.PackageVersions {
+		result.PackageVersions[name] = version
+	}
+	return result
+}
+
+func _addedValues(before []string, after []string) []string {
+	added := []string{}
+	for _, value := range after {
+		if len(_removeValue(before, value)) == len(before) {
+			added = append(added, value)
+		}
+	}
+	return added
+}
+
+// What changed on the system between two snapshots of the state
+func _stateChanges(before ExtensionState, after ExtensionState) ExtensionState {
+	changes := ExtensionState{
+		AddedRepos:        _addedValues(before.AddedRepos, after.AddedRepos),
+		ActivatedModules:  _addedValues(before.ActivatedModules, after.ActivatedModules),
+		InstalledPackages: _addedValues(before.InstalledPackages, after.InstalledPackages),
+		Certificates:      _addedValues(before.Certificates, after.Certificates),
+	}
+	removedBefore := []string{}
+	for _, repo := range before.RemovedRepos {
+		removedBefore = append(removedBefore, repo.Path)
+	}
+	for _, repo := range after.RemovedRepos {
+		if len(_addedValues(removedBefore, []string{repo.Path})) > 0 {
+			changes.RemovedRepos = append(changes.RemovedRepos, repo)
+		}
+	}
+	return changes
+}
+
+func (state ExtensionState) isEmpty() bool {
+	return len(state.AddedRepos) == 0 && len(state.ActivatedModules) == 0 &&
+		len(state.InstalledPackages) == 0 && len(state.Certificates) == 0 &&
+		len(state.RemovedRepos) == 0
+}
+
+func _isSubset(names []string, of []string) bool {
+	for _, name := range names {
+		found := false
+		for _, other := range of {
+			found = found || name == other
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Split the installed packages into those that can be removed without
+// taking other packages along, those already gone and those other
+// packages require. zypper remove would remove the packages requiring
+// them as well, packages installed later by the user included.
+func _getRemovablePackages(client *ZypperClient, packages []string) (removable []string, gone []string, required []string, err error) {
+	toRemove, err := client.RemovalSet(packages...)
+	if err == nil && _isSubset(toRemove, packages) {
+		return packages, nil, nil, nil
+	}
+	if err != nil && !errors.Is(err, ErrZypperCapNotFound) {
+		return nil, nil, nil, err
+	}
+	// one at a time to find the ones to keep
+	for _, name := range packages {
+		toRemove, err := client.RemovalSet(name)
+		switch {
+		case errors.Is(err, ErrZypperCapNotFound):
+			gone = append(gone, name)
+		case err != nil:
+			return nil, nil, nil, err
+		case _isSubset(toRemove, packages):
+			removable = append(removable, name)
+		default:
+			required = append(required, name)
+		}
+	}
+	return removable, gone, required, nil
+}
+
+// Remove the packages the extension installed, packages other packages
+// require are left alone and stay in the state
+func _removePackages(host *Host, packages []string, state *StateStore, logError func(step string, err error)) {
+	client := newZypperClient(host)
+	removable, gone, required, err := _getRemovablePackages(client, packages)
+	if err != nil {
+		logError("check the packages to remove", err)
+		return
+	}
+	for _, name := range required {
+		fmt.Println("Keeping package", name+", other packages require it")
+	}
+	if len(removable) > 0 {
+		if err = client.Remove(removable...); err != nil {
+			logError("remove packages", err)
+			return
+		}
+	}
+	if err = state.packagesRemoved(append(gone, removable...)); err != nil {
+		logError("record the removed packages", err)
+	}
+}
+
+// Undo the given changes in the reverse order install does them and
+// record every step in the state. Errors are passed to logError and the
+// remaining changes are still undone.
+func _undoChanges(host *Host, changes ExtensionState, state *StateStore, logError func(step string, err error)) {
+	if len(changes.InstalledPackages) > 0 {
+		_removePackages(host, changes.InstalledPackages, state, logError)
+	}
+	for _, triplet := range changes.ActivatedModules {
+		// deactivate against the server the module was activated with
+		client := newSUSEConnectClient(host, nil)
+		client.Url = state.State.RegistrationUrl
+		if err := client.Deactivate(triplet); err != nil {
+			logError("deactivate module "+triplet, err)
+		} else if err := state.moduleDeactivated(triplet); err != nil {
+			logError("record the deactivated module "+triplet, err)
+		}
+	}
+	for _, repoAlias := range changes.AddedRepos {
+		if err := _removeRepo(host, repoAlias, state); err != nil {
+			logError("remove repo "+repoAlias, err)
+		}
+	}
+	for _, repo := range changes.RemovedRepos {
+		// the backup keeps the file mode too
+		if _, err := _restoreRepoBackup(repo.Backup, repo.Path); err != nil {
+			logError("restore repo "+repo.Path, err)
+		} else if err := state.repoFileRestored(repo.Path); err != nil {
+			logError("record the restored repo "+repo.Path, err)
+		}
+	}
+	if len(changes.Certificates) > 0 {
+		for _, certificate := range changes.Certificates {
+			if err := os.Remove(certificate); err != nil && !os.IsNotExist(err) {
+				logError("remove certificate "+certificate, err)
+			} else if err := state.certificateRemoved(certificate); err != nil {
+				logError("record the removed certificate "+certificate, err)
+			}
+		}
+		if _, err := host.RunShellCommand(0, "update-ca-certificates"); err != nil {
+			logError("update CA certificates", err)
+		}
+	}
+}
+
+// Undo actions registered by the completed install steps
+type installTransaction struct {
+	steps []installStep
+}
+
+type installStep struct {
+	description string
+	changes     ExtensionState
+}
+
+func (transaction *installTransaction) register(description string, changes ExtensionState) {
+	if !changes.isEmpty() {
+		transaction.steps = append(transaction.steps, installStep{description, changes})
+	}
+}
+
+// Undo the registered steps, last one first
+func (transaction *installTransaction) rollback(host *Host, events EventLogger, state *StateStore) {
+	for i := len(transaction.steps) - 1; i >= 0; i-- {
+		step := transaction.steps[i]
+		fmt.Println("Rolling back install action:", step.description)
+		_undoChanges(host, step.changes, state, func(undoStep string, err error) {
+			fmt.Fprintln(os.Stderr, "Error when trying to", undoStep+":", err)
+			events.LogErrorEvent(
+				INSTALL_EVENT,
+				fmt.Sprintf(OPERATION_FAILURE_MSG, "Rollback of "+step.description, err.Error()))
+		})
+	}
+	transaction.steps = nil
+}
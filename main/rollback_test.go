@@ -0,0 +1,94 @@
+// Copyright (c) 2022, SUSE LLC, All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// zypper remove --dry-run answering with the packages it would remove
+func _zypperRemovalSet(toRemove []string, args ...string) ScriptedCommand {
+	stdout := "<?xml version='1.0'?>\n<stream>\n" +
+		"<message type=\"info\">Reading installed packages...</message>\n" +
+		"<install-summary download-size=\"0\" space-usage-diff=\"-1048576\" packages-to-change=\"1\">\n<to-remove>\n"
+	for _, name := range toRemove {
+		stdout += "<solvable type=\"package\" name=\"" + name + "\" edition=\"1.0-1\" arch=\"noarch\"/>\n"
+	}
+	stdout += "</to-remove>\n</install-summary>\n</stream>\n"
+	return ScriptedCommand{
+		Name:   "zypper",
+		Args:   append([]string{"--xmlout", "--non-interactive", "remove", "--dry-run"}, args...),
+		Result: CommandResult{Stdout: stdout},
+	}
+}
+
+// State of an install that added the given packages
+func newTestPackageState(t *testing.T, packages ...string) *StateStore {
+	state, err := _loadState(t.TempDir())
+	require.NoError(t, err)
+	for _, name := range packages {
+		require.NoError(t, state.packageInstalled(name))
+	}
+	return state
+}
+
+func TestUndoChangesRemovesInstalledPackages(t *testing.T) {
+	packages := []string{"cloud-regionsrv-client", "cloud-regionsrv-client-addon-azure"}
+	runner := &ScriptedCommandRunner{Commands: []ScriptedCommand{
+		_zypperRemovalSet(packages, packages...),
+		_zypperCommand(ZYPPER_EXIT_OK, append([]string{"remove"}, packages...)...),
+	}}
+	state := newTestPackageState(t, packages...)
+
+	_undoChanges(newTestHost(t, runner), state.State.copy(), state, func(step string, err error) {
+		t.Errorf("%s: %v", step, err)
+	})
+	assert.Empty(t, state.State.InstalledPackages)
+	assert.True(t, runner.Done(), "%v", runner.Calls)
+}
+
+func TestUndoChangesKeepsPackagesOthersRequire(t *testing.T) {
+	client := "cloud-regionsrv-client"
+	addon := "cloud-regionsrv-client-addon-azure"
+	plugin := "cloud-regionsrv-client-plugin-azure"
+	config := "regionServiceClientConfigAzure"
+	// installed by the user after the extension, requires the addon
+	userPackage := "azure-tools"
+	runner := &ScriptedCommandRunner{Commands: []ScriptedCommand{
+		_zypperRemovalSet([]string{client, addon, plugin, userPackage}, client, addon, plugin, config),
+		_zypperRemovalSet([]string{client, addon, userPackage}, client),
+		_zypperRemovalSet([]string{addon, userPackage}, addon),
+		_zypperRemovalSet([]string{plugin}, plugin),
+		// removed by the user meanwhile
+		_zypperCommand(ZYPPER_EXIT_INF_CAP_NOT_FOUND, "remove", "--dry-run", config),
+		_zypperCommand(ZYPPER_EXIT_OK, "remove", plugin),
+	}}
+	state := newTestPackageState(t, client, addon, plugin, config)
+
+	_undoChanges(newTestHost(t, runner), state.State.copy(), state, func(step string, err error) {
+		t.Errorf("%s: %v", step, err)
+	})
+	assert.Equal(t, []string{client, addon}, state.State.InstalledPackages)
+	assert.True(t, runner.Done(), "%v", runner.Calls)
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2022, SUSE LLC, All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// One line per installed package, fields separated by tabs
+const RPM_QUERY_FORMAT = "%{NAME}\\t%{EPOCH}\\t%{VERSION}\\t%{RELEASE}\\t%{ARCH}\\t%{VENDOR}\\n"
+
+// Package from the rpm database, Epoch is empty when the package has none
+type InstalledPackage struct {
+	Name    string `json:"name"`
+	Epoch   string `json:"epoch,omitempty"`
+	Version string `json:"version"`
+	Release string `json:"release"`
+	Arch    string `json:"arch"`
+	Vendor  string `json:"vendor"`
+}
+
+func (pkg InstalledPackage) EVR() string {
+	evr := pkg.Version + "-" + pkg.Release
+	if pkg.Epoch != "" {
+		evr = pkg.Epoch + ":" + evr
+	}
+	return evr
+}
+
+func _parseRpmQuery(output string) []InstalledPackage {
+	packages := []InstalledPackage{}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 6 {
+			// "package foo is not installed"
+			continue
+		}
+		for i := range fields {
+			if fields[i] == "(none)" {
+				fields[i] = ""
+			}
+		}
+		packages = append(packages, InstalledPackage{
+			Name:    fields[0],
+			Epoch:   fields[1],
+			Version: fields[2],
+			Release: fields[3],
+			Arch:    fields[4],
+			Vendor:  fields[5],
+		})
+	}
+	return packages
+}
+
+// Look the given packages up in the rpm database. Packages that are not
+// installed are missing from the result, for packages installed more
+// than once the newest one is returned.
+func _getInstalledPackages(host *Host, names ...string) (map[string]InstalledPackage, error) {
+	args := append([]string{"-q", "--queryformat", RPM_QUERY_FORMAT}, names...)
+	// rpm exits with the number of packages that are not installed
+	output, exitCode, err := host.RunShellCommandWithExitCode(0, "rpm", args...)
+	if exitCode < 0 || exitCode > len(names) {
+		return nil, err
+	}
+	installed := map[string]InstalledPackage{}
+	for _, pkg := range _parseRpmQuery(output) {
+		current, found := installed[pkg.Name]
+		if !found || _compareEVR(pkg.EVR(), current.EVR()) > 0 {
+			installed[pkg.Name] = pkg
+		}
+	}
+	if len(installed) != len(names)-exitCode {
+		return installed, fmt.Errorf("Unexpected rpm output for %s", strings.Join(names, " "))
+	}
+	return installed, nil
+}
+
+func _getAhbPackageNames(ahbInfo AHBInfo) []string {
+	return []string{ahbInfo.RegionSrv, ahbInfo.RegionSrvAddOn, ahbInfo.RegionSrvPlugin,
+		ahbInfo.RegionSrvConfig, ahbInfo.RegionSrvCerts}
+}
@@ -0,0 +1,191 @@
+// Copyright (c) 2022, SUSE LLC, All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Epoch, version and release of a package
+type EVR struct {
+	Epoch   int
+	Version string
+	Release string
+}
+
+// Split [epoch:]version[-release], a missing epoch is 0
+func _parseEVR(evr string) EVR {
+	result := EVR{}
+	if separator := strings.Index(evr, ":"); separator != -1 {
+		if epoch, err := strconv.Atoi(evr[:separator]); err == nil {
+			result.Epoch = epoch
+			evr = evr[separator+1:]
+		}
+	}
+	if separator := strings.LastIndex(evr, "-"); separator != -1 {
+		result.Release = evr[separator+1:]
+		evr = evr[:separator]
+	}
+	result.Version = evr
+	return result
+}
+
+func _isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func _isAlpha(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// Compare two version or release strings the way rpmvercmp does,
+// returns -1, 0 or 1. A tilde sorts before anything, even the end of
+// the string, a caret sorts after the end of the string but before
+// anything else.
+func _rpmVerCmp(a string, b string) int {
+	if a == b {
+		return 0
+	}
+	one, two := 0, 0
+	for one < len(a) || two < len(b) {
+		for one < len(a) && !_isDigit(a[one]) && !_isAlpha(a[one]) && a[one] != '~' && a[one] != '^' {
+			one++
+		}
+		for two < len(b) && !_isDigit(b[two]) && !_isAlpha(b[two]) && b[two] != '~' && b[two] != '^' {
+			two++
+		}
+
+		// tilde separator
+		oneTilde := one < len(a) && a[one] == '~'
+		twoTilde := two < len(b) && b[two] == '~'
+		if oneTilde || twoTilde {
+			if !oneTilde {
+				return 1
+			}
+			if !twoTilde {
+				return -1
+			}
+			one++
+			two++
+			continue
+		}
+
+		// caret separator, like tilde except that the end of the
+		// string sorts before it
+		oneCaret := one < len(a) && a[one] == '^'
+		twoCaret := two < len(b) && b[two] == '^'
+		if oneCaret || twoCaret {
+			if one >= len(a) {
+				return -1
+			}
+			if two >= len(b) {
+				return 1
+			}
+			if !oneCaret {
+				return 1
+			}
+			if !twoCaret {
+				return -1
+			}
+			one++
+			two++
+			continue
+		}
+
+		if one >= len(a) || two >= len(b) {
+			break
+		}
+
+		oneEnd, twoEnd := one, two
+		isNum := _isDigit(a[one])
+		if isNum {
+			for oneEnd < len(a) && _isDigit(a[oneEnd]) {
+				oneEnd++
+			}
+			for twoEnd < len(b) && _isDigit(b[twoEnd]) {
+				twoEnd++
+			}
+		} else {
+			for oneEnd < len(a) && _isAlpha(a[oneEnd]) {
+				oneEnd++
+			}
+			for twoEnd < len(b) && _isAlpha(b[twoEnd]) {
+				twoEnd++
+			}
+		}
+
+		// segments of different types, numeric is newer
+		if twoEnd == two {
+			if isNum {
+				return 1
+			}
+			return -1
+		}
+
+		oneSegment, twoSegment := a[one:oneEnd], b[two:twoEnd]
+		if isNum {
+			oneSegment = strings.TrimLeft(oneSegment, "0")
+			twoSegment = strings.TrimLeft(twoSegment, "0")
+			// the number with more digits wins
+			if len(oneSegment) != len(twoSegment) {
+				if len(oneSegment) > len(twoSegment) {
+					return 1
+				}
+				return -1
+			}
+		}
+		if result := strings.Compare(oneSegment, twoSegment); result != 0 {
+			return result
+		}
+		one, two = oneEnd, twoEnd
+	}
+
+	// all segments are equal but the separators may differ
+	if one >= len(a) && two >= len(b) {
+		return 0
+	}
+	// whichever version still has characters left over wins
+	if one >= len(a) {
+		return -1
+	}
+	return 1
+}
+
+// Compare two [epoch:]version[-release] strings. The release is only
+// compared when both have one, as rpm does for versioned dependencies,
+// so "9.3.1" matches any release of 9.3.1.
+func _compareEVR(first string, second string) int {
+	one, two := _parseEVR(first), _parseEVR(second)
+	if one.Epoch != two.Epoch {
+		if one.Epoch > two.Epoch {
+			return 1
+		}
+		return -1
+	}
+	if result := _rpmVerCmp(one.Version, two.Version); result != 0 {
+		return result
+	}
+	if one.Release == "" || two.Release == "" {
+		return 0
+	}
+	return _rpmVerCmp(one.Release, two.Release)
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2022, SUSE LLC, All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRpmVerCmp(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "2.0", -1},
+		{"2.0", "1.0", 1},
+		{"2.0.1", "2.0.1", 0},
+		{"2.0", "2.0.1", -1},
+		{"2.0.1", "2.0", 1},
+		{"1.05", "1.5", 0},
+		{"1.0010", "1.9", 1},
+		{"2.50", "2.5", 1},
+		{"1.0", "1", 1},
+		// separators only split segments
+		{"fc4", "fc.4", 0},
+		{"3.0.0_fc", "3.0.0.fc", 0},
+		{"1++", "1_", 0},
+		{"+1", "_1", 0},
+		// alphanumeric segments
+		{"2.0.1a", "2.0.1", 1},
+		{"5.5p1", "5.5p2", -1},
+		{"5.5p10", "5.5p1", 1},
+		{"10xyz", "10.1xyz", -1},
+		{"xyz10", "xyz10.1", -1},
+		{"xyz.4", "8", -1},
+		{"8", "xyz.4", 1},
+		{"FC5", "fc4", -1},
+		{"2a", "2.0", -1},
+		{"1.0", "1.fc4", 1},
+		// tilde sorts before anything
+		{"1.0~rc1", "1.0", -1},
+		{"1.0", "1.0~rc1", 1},
+		{"1.0~rc1", "1.0~rc2", -1},
+		{"1.0~rc1~git123", "1.0~rc1", -1},
+		{"1.0~rc1", "1.0arc1", -1},
+		// caret sorts after the end but before anything else
+		{"1.0^", "1.0", 1},
+		{"1.0^git1", "1.0", 1},
+		{"1.0", "1.0^git1", -1},
+		{"1.0^git1", "1.0^git2", -1},
+		{"1.0^git1", "1.01", -1},
+		{"1.0^20160101", "1.0.1", -1},
+		{"1.0^git1~pre", "1.0^git1", -1},
+		{"1.0~rc1^git1", "1.0~rc1", 1},
+		{"1.0~rc1^git1", "1.0", -1},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, _rpmVerCmp(test.a, test.b), "%s <=> %s", test.a, test.b)
+	}
+}
+
+func TestParseEVR(t *testing.T) {
+	assert.Equal(t, EVR{0, "9.3.1", ""}, _parseEVR("9.3.1"))
+	assert.Equal(t, EVR{0, "9.3.1", "150000.1.2"}, _parseEVR("9.3.1-150000.1.2"))
+	assert.Equal(t, EVR{2, "1.0", "3"}, _parseEVR("2:1.0-3"))
+	assert.Equal(t, EVR{0, "1.0~rc1", "1"}, _parseEVR("1.0~rc1-1"))
+}
+
+func TestCompareEVR(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		// epoch wins over version and release
+		{"1:1.0", "2.0", 1},
+		{"2.0", "1:1.0", -1},
+		{"0:1.0-1", "1.0-1", 0},
+		{"1:1.0-1", "1:1.0-1", 0},
+		// release is only compared when both have one
+		{"9.3.1", "9.3.1-150000.1.2", 0},
+		{"9.3.1-150000.1.2", "9.3.1", 0},
+		{"9.3.1-2", "9.3.1-10", -1},
+		{"9.3.1-150400.1.1", "9.3.1-150000.3.1", 1},
+		{"9.3.0-5", "9.3.1", -1},
+		{"10.0.0-1", "9.3.1", 1},
+		{"9.3.1~rc1-1", "9.3.1", -1},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, _compareEVR(test.a, test.b), "%s <=> %s", test.a, test.b)
+	}
+}
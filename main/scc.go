@@ -0,0 +1,179 @@
+// Copyright (c) 2022, SUSE LLC, All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	SCC_DEFAULT_URL     = "https://scc.suse.com"
+	SCC_DEFAULT_TIMEOUT = 30 * time.Second
+	SCC_DEFAULT_RETRIES = 3
+	SCC_DEFAULT_BACKOFF = 2 * time.Second
+)
+
+var (
+	ErrSCCUnauthorized    = errors.New("SCC rejected the system credentials")
+	ErrSCCNoSubscriptions = errors.New("SCC returned no subscriptions for the system")
+)
+
+// Error for any unexpected status code returned by SCC
+type SCCStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *SCCStatusError) Error() string {
+	return fmt.Sprintf("SCC returned status %d: %s", e.StatusCode, e.Body)
+}
+
+// Subscription as returned by /connect/systems/subscriptions
+type SCCSubscription struct {
+	Id             int        `json:"id"`
+	Regcode        string     `json:"regcode"`
+	Name           string     `json:"name"`
+	Type           string     `json:"type"`
+	Status         string     `json:"status"`
+	StartsAt       *time.Time `json:"starts_at"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+	SystemsCount   int        `json:"systems_count"`
+	VirtualCount   *int       `json:"virtual_count"`
+	ProductClasses []string   `json:"product_classes"`
+	ProductIds     []int      `json:"product_ids"`
+	Skus           []string   `json:"skus"`
+}
+
+func (subscription SCCSubscription) IsActive() bool {
+	return strings.ToLower(subscription.Status) == "active"
+}
+
+// Client for the SCC connect API, authenticated with the system credentials
+type SCCClient struct {
+	BaseUrl    string
+	HttpClient *http.Client
+	// attempts after the first one on network errors and 5xx
+	Retries int
+	// wait before the first retry, doubled on every retry
+	Backoff     time.Duration
+	Username    string
+	Password    string
+	SystemToken string
+	sleep       func(wait time.Duration)
+}
+
+func newSCCClient(username string, password string) *SCCClient {
+	return &SCCClient{
+		BaseUrl:    SCC_DEFAULT_URL,
+		HttpClient: &http.Client{Timeout: SCC_DEFAULT_TIMEOUT},
+		Retries:    SCC_DEFAULT_RETRIES,
+		Backoff:    SCC_DEFAULT_BACKOFF,
+		Username:   username,
+		Password:   password,
+		sleep:      time.Sleep,
+	}
+}
+
+func (client *SCCClient) _doGet(URL string) (int, []byte, error) {
+	req, err := http.NewRequest("GET", URL, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Add("accept", "application/json")
+	req.SetBasicAuth(client.Username, client.Password)
+	if client.SystemToken != "" {
+		req.Header.Add("System-Token", client.SystemToken)
+	}
+	resp, err := client.HttpClient.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, nil, err
+	}
+	return resp.StatusCode, body, nil
+}
+
+// GET the given API path and decode the JSON response into result,
+// network errors and server errors are retried with backoff
+func (client *SCCClient) get(path string, result interface{}) error {
+	URL := strings.TrimSuffix(client.BaseUrl, "/") + path
+	backoff := client.Backoff
+	var lastError error
+	for attempt := 0; attempt <= client.Retries; attempt++ {
+		if attempt > 0 {
+			client.sleep(backoff)
+			backoff *= 2
+		}
+		statusCode, body, err := client._doGet(URL)
+		if err != nil {
+			lastError = err
+			continue
+		}
+		switch {
+		case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+			return fmt.Errorf("%w (status %d)", ErrSCCUnauthorized, statusCode)
+		case statusCode >= 500:
+			lastError = &SCCStatusError{StatusCode: statusCode, Body: string(body)}
+			continue
+		case statusCode != http.StatusOK:
+			return &SCCStatusError{StatusCode: statusCode, Body: string(body)}
+		}
+		if err = json.Unmarshal(body, result); err != nil {
+			return fmt.Errorf("Could not parse SCC response for %s: %v", path, err)
+		}
+		return nil
+	}
+	return lastError
+}
+
+// Every subscription the system is entitled to
+func (client *SCCClient) GetSubscriptions() ([]SCCSubscription, error) {
+	var subscriptions []SCCSubscription
+	if err := client.get("/connect/systems/subscriptions", &subscriptions); err != nil {
+		return nil, err
+	}
+	if len(subscriptions) == 0 {
+		return nil, ErrSCCNoSubscriptions
+	}
+	return subscriptions, nil
+}
+
+func _reportSubscriptions(out io.Writer, subscriptions []SCCSubscription) {
+	for _, subscription := range subscriptions {
+		expiresAt := "never"
+		if subscription.ExpiresAt != nil {
+			expiresAt = subscription.ExpiresAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(out, "Subscription %q (%s): status=%s expires=%s products=%s\n",
+			subscription.Name, subscription.Type, subscription.Status, expiresAt,
+			strings.Join(subscription.ProductClasses, ","))
+	}
+}
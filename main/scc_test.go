@@ -0,0 +1,180 @@
+// Copyright (c) 2022, SUSE LLC, All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sccSubscriptionsResponse = `[{
+	"id": 1,
+	"regcode": "REGCODE",
+	"name": "SUSE Linux Enterprise Server",
+	"type": "full",
+	"status": "ACTIVE",
+	"expires_at": "2030-01-01T00:00:00.000Z",
+	"product_classes": ["7261"]
+}]`
+
+// SCC client talking to the test server, sleeps are recorded instead
+func newTestSCCClient(server *httptest.Server, sleeps *[]time.Duration) *SCCClient {
+	client := newSCCClient("SCC_user", "secret")
+	client.BaseUrl = server.URL
+	client.sleep = func(wait time.Duration) {
+		*sleeps = append(*sleeps, wait)
+	}
+	return client
+}
+
+func TestSCCClientGetSubscriptions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "SCC_user", username)
+		assert.Equal(t, "secret", password)
+		assert.Equal(t, "token", r.Header.Get("System-Token"))
+		assert.Equal(t, "/connect/systems/subscriptions", r.URL.Path)
+		w.Write([]byte(sccSubscriptionsResponse))
+	}))
+	defer server.Close()
+	sleeps := []time.Duration{}
+	client := newTestSCCClient(server, &sleeps)
+	client.SystemToken = "token"
+
+	subscriptions, err := client.GetSubscriptions()
+	require.NoError(t, err)
+	require.Len(t, subscriptions, 1)
+	assert.Equal(t, "SUSE Linux Enterprise Server", subscriptions[0].Name)
+	assert.True(t, subscriptions[0].IsActive())
+	require.NotNil(t, subscriptions[0].ExpiresAt)
+	assert.Equal(t, 2030, subscriptions[0].ExpiresAt.Year())
+	assert.Empty(t, sleeps)
+}
+
+func TestSCCClientUnauthorized(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+	sleeps := []time.Duration{}
+
+	_, err := newTestSCCClient(server, &sleeps).GetSubscriptions()
+	assert.ErrorIs(t, err, ErrSCCUnauthorized)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&requests), "401 must not be retried")
+}
+
+func TestSCCClientNoSubscriptions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+	sleeps := []time.Duration{}
+
+	_, err := newTestSCCClient(server, &sleeps).GetSubscriptions()
+	assert.ErrorIs(t, err, ErrSCCNoSubscriptions)
+}
+
+func TestSCCClientRetriesServerErrors(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(sccSubscriptionsResponse))
+	}))
+	defer server.Close()
+	sleeps := []time.Duration{}
+
+	subscriptions, err := newTestSCCClient(server, &sleeps).GetSubscriptions()
+	require.NoError(t, err)
+	assert.Len(t, subscriptions, 1)
+	assert.EqualValues(t, 3, atomic.LoadInt32(&requests))
+	assert.Equal(t, []time.Duration{SCC_DEFAULT_BACKOFF, 2 * SCC_DEFAULT_BACKOFF}, sleeps)
+}
+
+func TestSCCClientGivesUpAfterRetries(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("bad gateway"))
+	}))
+	defer server.Close()
+	sleeps := []time.Duration{}
+
+	_, err := newTestSCCClient(server, &sleeps).GetSubscriptions()
+	var statusError *SCCStatusError
+	require.True(t, errors.As(err, &statusError))
+	assert.Equal(t, http.StatusBadGateway, statusError.StatusCode)
+	assert.Equal(t, "bad gateway", statusError.Body)
+	assert.EqualValues(t, SCC_DEFAULT_RETRIES+1, atomic.LoadInt32(&requests))
+	assert.Equal(t, []time.Duration{SCC_DEFAULT_BACKOFF, 2 * SCC_DEFAULT_BACKOFF, 4 * SCC_DEFAULT_BACKOFF}, sleeps)
+}
+
+func TestSCCClientDoesNotRetryClientErrors(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	sleeps := []time.Duration{}
+
+	_, err := newTestSCCClient(server, &sleeps).GetSubscriptions()
+	var statusError *SCCStatusError
+	require.True(t, errors.As(err, &statusError))
+	assert.Equal(t, http.StatusNotFound, statusError.StatusCode)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&requests))
+}
+
+func TestSCCClientTimeout(t *testing.T) {
+	assert.Equal(t, 30*time.Second, newSCCClient("", "").HttpClient.Timeout)
+
+	release := make(chan struct{})
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+	sleeps := []time.Duration{}
+	client := newTestSCCClient(server, &sleeps)
+	// the default of 30s, shortened for the test
+	client.HttpClient.Timeout = 50 * time.Millisecond
+
+	_, err := client.GetSubscriptions()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Client.Timeout")
+	assert.EqualValues(t, SCC_DEFAULT_RETRIES+1, atomic.LoadInt32(&requests), "timeouts are retried")
+	assert.Len(t, sleeps, SCC_DEFAULT_RETRIES)
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2022, SUSE LLC, All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-extension-platform/vmextension"
+)
+
+var (
+	packageNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9+._-]*$`)
+	rpmVersionPattern  = regexp.MustCompile(`^([0-9]+:)?[A-Za-z0-9._+~^]+$`)
+	repoAliasPattern   = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+	unitNamePattern    = regexp.MustCompile(`^[A-Za-z0-9:_.@-]+\.timer$`)
+)
+
+// Validation rules for every AHBInfo setting, keyed by the JSON name
+// used in the public settings
+var ahbInfoSchema = map[string]func(value string) error{
+	"publicCloudService":     _matches(packageNamePattern),
+	"registerCloudGuestPath": _isAbsolutePath,
+	"regionSrvMinVer":        _matches(rpmVersionPattern),
+	"regionSrvEnablerTimer":  _matches(unitNamePattern),
+	"regionSrv":              _matches(packageNamePattern),
+	"regionSrvAddOn":         _matches(packageNamePattern),
+	"regionSrvPlugin":        _matches(packageNamePattern),
+	"regionSrvConfig":        _matches(packageNamePattern),
+	"regionSrvCerts":         _matches(packageNamePattern),
+	"addonPath":              _isAbsolutePath,
+	"repoAlias":              _matches(repoAliasPattern),
+	"modName":                _matches(packageNamePattern),
+	"repoUrl":                _isRepoUrlTemplate,
+}
+
+func _matches(pattern *regexp.Regexp) func(value string) error {
+	return func(value string) error {
+		if !pattern.MatchString(value) {
+			return fmt.Errorf("'%s' does not match %s", value, pattern.String())
+		}
+		return nil
+	}
+}
+
+func _isAbsolutePath(value string) error {
+	if !filepath.IsAbs(value) {
+		return fmt.Errorf("'%s' is not an absolute path", value)
+	}
+	return nil
+}
+
+// The repo URL is a template filled in with the version and the arch
+func _isRepoUrlTemplate(value string) error {
+	if strings.Count(value, "%s") != 2 || strings.Count(value, "%") != 2 {
+		return fmt.Errorf("'%s' must contain exactly two %%s, for the version and the arch", value)
+	}
+	repoUrl, err := url.Parse(fmt.Sprintf(value, "15", "x86_64"))
+	if err != nil {
+		return err
+	}
+	if repoUrl.Scheme != "https" && repoUrl.Scheme != "http" {
+		return fmt.Errorf("'%s' is not an http(s) URL", value)
+	}
+	if repoUrl.Host == "" {
+		return fmt.Errorf("'%s' has no host", value)
+	}
+	return nil
+}
+
+func _validateAhbInfo(ahbInfo AHBInfo) error {
+	// go through the JSON form so that errors name the setting
+	content, err := json.Marshal(ahbInfo)
+	if err != nil {
+		return err
+	}
+	values := map[string]string{}
+	if err = json.Unmarshal(content, &values); err != nil {
+		return err
+	}
+	for name, validate := range ahbInfoSchema {
+		value, ok := values[name]
+		if !ok || value == "" {
+			return fmt.Errorf("Invalid setting '%s': value is empty", name)
+		}
+		if err = validate(value); err != nil {
+			return fmt.Errorf("Invalid setting '%s': %v", name, err)
+		}
+	}
+	return nil
+}
+
+// Public settings: the AHBInfo overrides and the extension options
+type PublicSettings struct {
+	AHBInfo
+	// plan the install and report it without changing anything
+	DryRun bool `json:"dryRun"`
+	// run the enabler once in enable and fail if it does not succeed
+	VerifyEnabler bool `json:"verifyEnabler"`
+	// seconds to wait for the enabler, 0 for the default
+	VerifyEnablerTimeout int `json:"verifyEnablerTimeout"`
+	// credentials file tried before the SCCcredentials of zypp
+	CredentialsPath string `json:"credentialsPath"`
+}
+
+// Apply the overrides from the public settings JSON on top of the
+// defaults, unknown settings are rejected
+func _parsePublicSettings(publicSettings string, defaults AHBInfo) (PublicSettings, error) {
+	settings := PublicSettings{AHBInfo: defaults}
+	publicSettings = strings.TrimSpace(publicSettings)
+	if publicSettings != "" && publicSettings != "null" {
+		decoder := json.NewDecoder(bytes.NewReader([]byte(publicSettings)))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&settings); err != nil {
+			return PublicSettings{AHBInfo: defaults}, fmt.Errorf("Invalid public settings: %v", err)
+		}
+	}
+	if err := _validateAhbInfo(settings.AHBInfo); err != nil {
+		return PublicSettings{AHBInfo: defaults}, err
+	}
+	if settings.VerifyEnablerTimeout < 0 {
+		return PublicSettings{AHBInfo: defaults}, fmt.Errorf("Invalid setting 'verifyEnablerTimeout': must not be negative")
+	}
+	if maxTimeout := int(ENABLER_VERIFY_MAX_TIMEOUT / time.Second); settings.VerifyEnablerTimeout > maxTimeout {
+		return PublicSettings{AHBInfo: defaults}, fmt.Errorf(
+			"Invalid setting 'verifyEnablerTimeout': at most %d seconds fit in the time the agent gives enable", maxTimeout)
+	}
+	if settings.CredentialsPath != "" {
+		if err := _isAbsolutePath(settings.CredentialsPath); err != nil {
+			return PublicSettings{AHBInfo: defaults}, fmt.Errorf("Invalid setting 'credentialsPath': %v", err)
+		}
+	}
+	return settings, nil
+}
+
+func getPublicSettings(ext *vmextension.VMExtension) (PublicSettings, error) {
+	extensionSettings, err := ext.GetSettings()
+	if err != nil {
+		return PublicSettings{AHBInfo: getAhbInfo()}, fmt.Errorf("Could not read extension settings: %v", err)
+	}
+	return _parsePublicSettings(extensionSettings.PublicSettings, getAhbInfo())
+}
+
+func getAhbInfoFromSettings(ext *vmextension.VMExtension) (AHBInfo, error) {
+	settings, err := getPublicSettings(ext)
+	return settings.AHBInfo, err
+}
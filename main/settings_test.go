@@ -0,0 +1,44 @@
+// Copyright (c) 2022, SUSE LLC, All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePublicSettingsVerifyEnablerTimeout(t *testing.T) {
+	settings, err := _parsePublicSettings(`{"verifyEnabler": true}`, getAhbInfo())
+	require.NoError(t, err)
+	assert.True(t, settings.VerifyEnabler)
+	assert.Equal(t, 0, settings.VerifyEnablerTimeout)
+
+	settings, err = _parsePublicSettings(`{"verifyEnabler": true, "verifyEnablerTimeout": 180}`, getAhbInfo())
+	require.NoError(t, err)
+	assert.Equal(t, 180, settings.VerifyEnablerTimeout)
+
+	for _, timeout := range []string{"-1", "181", "300"} {
+		_, err = _parsePublicSettings(`{"verifyEnabler": true, "verifyEnablerTimeout": `+timeout+`}`, getAhbInfo())
+		assert.Error(t, err, timeout)
+	}
+}
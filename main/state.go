@@ -0,0 +1,291 @@
+// Copyright (c) 2022, SUSE LLC, All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	stateFileName      = "ahb_state.json"
+	stateFormatVersion = 1
+)
+
+const (
+	REGISTRATION_REGISTERED           = "registered"
+	REGISTRATION_RMT                  = "rmt"
+	REGISTRATION_SUBSCRIPTION_EXPIRED = "subscription-expired"
+	REGISTRATION_UNVERIFIED           = "registered-unverified"
+	REGISTRATION_UNREGISTERED         = "unregistered"
+)
+
+// Repository file deleted by the install callback, Backup is the
+// directory of the backup it is written back from on uninstall
+type RemovedRepo struct {
+	Path   string `json:"path"`
+	Backup string `json:"backup"`
+}
+
+type TimerState struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+	Active  bool   `json:"active"`
+}
+
+// Facts about what the extension did on the VM. Uninstall only rolls
+// back what is listed here, anything that was already present on the VM
+// before the extension was installed is left alone.
+type ExtensionState struct {
+	Version          int       `json:"version"`
+	ExtensionVersion string    `json:"extensionVersion"`
+	UpdatedAt        time.Time `json:"updatedAt"`
+	RegistrationMode string    `json:"registrationMode,omitempty"`
+	// server the modules were activated with, empty for SCC
+	RegistrationUrl   string            `json:"registrationUrl,omitempty"`
+	AddedRepos        []string          `json:"addedRepos"`
+	ActivatedModules  []string          `json:"activatedModules"`
+	InstalledPackages []string          `json:"installedPackages"`
+	PackageVersions   map[string]string `json:"packageVersions"`
+	RemovedRepos      []RemovedRepo     `json:"removedRepos"`
+	Certificates      []string          `json:"certificates"`
+	Timer             TimerState        `json:"timer"`
+	// set by enable, only uninstall clears it, disable leaves it alone
+	EnabledByUser bool `json:"enabledByUser"`
+}
+
+// The state journal, every change is written to disk right away so that
+// an interrupted invocation still leaves an accurate record behind
+type StateStore struct {
+	path  string
+	State ExtensionState
+}
+
+func _appendUnique(list []string, value string) []string {
+	for _, item := range list {
+		if item == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
+
+func _removeValue(list []string, value string) []string {
+	result := []string{}
+	for _, item := range list {
+		if item != value {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+func _newExtensionState() ExtensionState {
+	return ExtensionState{
+		Version:         stateFormatVersion,
+		PackageVersions: map[string]string{},
+	}
+}
+
+func _loadState(dataDir string) (*StateStore, error) {
+	store := &StateStore{
+		path:  filepath.Join(dataDir, stateFileName),
+		State: _newExtensionState(),
+	}
+	content, err := ioutil.ReadFile(store.path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return store, err
+	}
+	if err = json.Unmarshal(content, &store.State); err != nil {
+		return store, fmt.Errorf("Could not parse '%v': %v", store.path, err)
+	}
+	if store.State.Version > stateFormatVersion {
+		return store, fmt.Errorf("State file '%v' has version %d, only up to %d is supported",
+			store.path, store.State.Version, stateFormatVersion)
+	}
+	store.State.Version = stateFormatVersion
+	if store.State.PackageVersions == nil {
+		store.State.PackageVersions = map[string]string{}
+	}
+	return store, nil
+}
+
+// Write the state to a temporary file and rename it over the journal,
+// readers never see a half written file
+func (store *StateStore) save() error {
+	store.State.UpdatedAt = time.Now().UTC()
+	content, err := json.MarshalIndent(store.State, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(store.path)
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmpFile, err := ioutil.TempFile(dir, stateFileName+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err = tmpFile.Write(content); err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), store.path)
+}
+
+// Apply a change to the state and persist it. The change is kept in
+// memory when saving fails, the caller has to fail the step: a change
+// missing on disk is not undone by the next uninstall.
+func (store *StateStore) update(change func(state *ExtensionState)) error {
+	change(&store.State)
+	if err := store.save(); err != nil {
+		return fmt.Errorf("Could not save the extension state: %v", err)
+	}
+	return nil
+}
+
+func (store *StateStore) dataDir() string {
+	return filepath.Dir(store.path)
+}
+
+func (store *StateStore) delete() error {
+	err := os.Remove(store.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (store *StateStore) repoAdded(repoAlias string) error {
+	return store.update(func(state *ExtensionState) {
+		state.AddedRepos = _appendUnique(state.AddedRepos, repoAlias)
+	})
+}
+
+func (store *StateStore) repoRemoved(repoAlias string) error {
+	return store.update(func(state *ExtensionState) {
+		state.AddedRepos = _removeValue(state.AddedRepos, repoAlias)
+	})
+}
+
+func (store *StateStore) moduleActivated(triplet string, registrationUrl string) error {
+	return store.update(func(state *ExtensionState) {
+		state.ActivatedModules = _appendUnique(state.ActivatedModules, triplet)
+		state.RegistrationUrl = registrationUrl
+	})
+}
+
+func (store *StateStore) moduleDeactivated(triplet string) error {
+	return store.update(func(state *ExtensionState) {
+		state.ActivatedModules = _removeValue(state.ActivatedModules, triplet)
+	})
+}
+
+func (store *StateStore) packageInstalled(name string) error {
+	return store.update(func(state *ExtensionState) {
+		state.InstalledPackages = _appendUnique(state.InstalledPackages, name)
+	})
+}
+
+func (store *StateStore) packagesRemoved(names []string) error {
+	return store.update(func(state *ExtensionState) {
+		for _, name := range names {
+			delete(state.PackageVersions, name)
+			state.InstalledPackages = _removeValue(state.InstalledPackages, name)
+		}
+	})
+}
+
+func (store *StateStore) packageVersions(installed map[string]InstalledPackage) error {
+	return store.update(func(state *ExtensionState) {
+		state.PackageVersions = map[string]string{}
+		for name, pkg := range installed {
+			state.PackageVersions[name] = pkg.EVR()
+		}
+	})
+}
+
+func (store *StateStore) repoFileRemoved(path string, backup string) error {
+	return store.update(func(state *ExtensionState) {
+		state.RemovedRepos = append(state.RemovedRepos, RemovedRepo{Path: path, Backup: backup})
+	})
+}
+
+func (store *StateStore) repoFileRestored(path string) error {
+	return store.update(func(state *ExtensionState) {
+		remaining := []RemovedRepo{}
+		for _, repo := range state.RemovedRepos {
+			if repo.Path != path {
+				remaining = append(remaining, repo)
+			}
+		}
+		state.RemovedRepos = remaining
+	})
+}
+
+func (store *StateStore) certificateInstalled(path string) error {
+	return store.update(func(state *ExtensionState) {
+		state.Certificates = _appendUnique(state.Certificates, path)
+	})
+}
+
+func (store *StateStore) certificateRemoved(path string) error {
+	return store.update(func(state *ExtensionState) {
+		state.Certificates = _removeValue(state.Certificates, path)
+	})
+}
+
+func (store *StateStore) registrationMode(mode string) error {
+	return store.update(func(state *ExtensionState) {
+		state.RegistrationMode = mode
+	})
+}
+
+func (store *StateStore) timerState(name string, enabled bool, active bool) error {
+	return store.update(func(state *ExtensionState) {
+		state.Timer = TimerState{Name: name, Enabled: enabled, Active: active}
+	})
+}
+
+func (store *StateStore) enabledByUser(enabled bool) error {
+	return store.update(func(state *ExtensionState) {
+		state.EnabledByUser = enabled
+	})
+}
+
+func (store *StateStore) extensionVersion(version string) error {
+	return store.update(func(state *ExtensionState) {
+		state.ExtensionVersion = version
+	})
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2022, SUSE LLC, All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustStat(t *testing.T, path string) os.FileInfo {
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	return info
+}
+
+func TestStateSaveAndReload(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data")
+	state, err := _loadState(dir)
+	require.NoError(t, err)
+	require.NoError(t, state.repoAdded("sle-ahb-packages"))
+	require.NoError(t, state.packageInstalled("cloud-regionsrv-client"))
+	require.NoError(t, state.registrationMode(REGISTRATION_UNREGISTERED))
+
+	// only the journal is left behind, no temporary files
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, stateFileName, files[0].Name())
+	assert.Equal(t, os.FileMode(0700), mustStat(t, dir).Mode().Perm())
+
+	reloaded, err := _loadState(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sle-ahb-packages"}, reloaded.State.AddedRepos)
+	assert.Equal(t, []string{"cloud-regionsrv-client"}, reloaded.State.InstalledPackages)
+	assert.Equal(t, REGISTRATION_UNREGISTERED, reloaded.State.RegistrationMode)
+	assert.Equal(t, stateFormatVersion, reloaded.State.Version)
+}
+
+func TestLoadStateRejectsNewerVersion(t *testing.T) {
+	dir := t.TempDir()
+	_writeTestFile(t, filepath.Join(dir, stateFileName), `{"version": 999}`, 0600)
+	_, err := _loadState(dir)
+	assert.Error(t, err)
+}
+
+func TestLoadStateWithoutPackageVersions(t *testing.T) {
+	dir := t.TempDir()
+	_writeTestFile(t, filepath.Join(dir, stateFileName), `{"version": 1, "packageVersions": null}`, 0600)
+	state, err := _loadState(dir)
+	require.NoError(t, err)
+	require.NotNil(t, state.State.PackageVersions)
+	// recording versions must not panic on the loaded state
+	require.NoError(t, state.packageVersions(map[string]InstalledPackage{
+		"cloud-regionsrv-client": {Name: "cloud-regionsrv-client", Version: "10.1.0", Release: "1"},
+	}))
+	assert.Equal(t, map[string]string{"cloud-regionsrv-client": "10.1.0-1"}, state.State.PackageVersions)
+}
+
+func TestStateSaveErrorFailsTheStep(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data")
+	state, err := _loadState(dir)
+	require.NoError(t, err)
+	// a regular file where the data directory should be
+	_writeTestFile(t, dir, "", 0600)
+
+	runner := &ScriptedCommandRunner{Commands: []ScriptedCommand{
+		_zypperCommand(ZYPPER_EXIT_OK, "addrepo", "https://example.com/repo", "repo"),
+	}}
+	err = _addRepo(newTestHost(t, runner), "repo", "https://example.com/repo", state)
+	assert.Error(t, err)
+	// kept in memory, so the rollback of the failed step removes it
+	assert.Equal(t, []string{"repo"}, state.State.AddedRepos)
+}
@@ -0,0 +1,163 @@
+// Copyright (c) 2022, SUSE LLC, All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+const (
+	SUBSTATUS_SUCCESS = "success"
+	SUBSTATUS_WARNING = "warning"
+	SUBSTATUS_ERROR   = "error"
+)
+
+// Same shape as the substatus entries of the extension status file. The
+// platform only lets enable return the message, so they are part of it.
+type SubStatus struct {
+	Name             string           `json:"name"`
+	Status           string           `json:"status"`
+	Code             int              `json:"code"`
+	FormattedMessage FormattedMessage `json:"formattedMessage"`
+}
+
+type TimerStatus struct {
+	Name        string `json:"name"`
+	Enabled     bool   `json:"enabled"`
+	Active      bool   `json:"active"`
+	NextTrigger string `json:"nextTrigger,omitempty"`
+	LastTrigger string `json:"lastTrigger,omitempty"`
+}
+
+// Status message of enable, Status is the result of enabling the
+// timer, the substatus entries say what makes the VM (not) ready for AHB
+type EnableStatus struct {
+	Status           string            `json:"status"`
+	RegistrationMode string            `json:"registrationMode"`
+	Packages         map[string]string `json:"packages"`
+	Timer            TimerStatus       `json:"timer"`
+	Substatus        []SubStatus       `json:"substatus"`
+}
+
+func (status *EnableStatus) add(name string, result string, message string) {
+	code := 0
+	if result == SUBSTATUS_ERROR {
+		code = 1
+	}
+	status.Substatus = append(status.Substatus, SubStatus{
+		Name:             name,
+		Status:           result,
+		Code:             code,
+		FormattedMessage: FormattedMessage{Lang: "en-US", Message: message},
+	})
+}
+
+func (status *EnableStatus) String() string {
+	content, err := json.Marshal(status)
+	if err != nil {
+		return status.Status
+	}
+	return string(content)
+}
+
+func _describeRegistrationMode(mode string) (string, string) {
+	switch mode {
+	case REGISTRATION_REGISTERED:
+		return SUBSTATUS_SUCCESS, "Registered with SCC, active subscription"
+	case REGISTRATION_RMT:
+		return SUBSTATUS_SUCCESS, "Registered with the RMT server"
+	case REGISTRATION_UNREGISTERED:
+		return SUBSTATUS_SUCCESS, "Not registered, packages from the unrestricted repository"
+	case REGISTRATION_SUBSCRIPTION_EXPIRED:
+		return SUBSTATUS_WARNING, "Registered with SCC, subscription expired, packages from the unrestricted repository"
+	case REGISTRATION_UNVERIFIED:
+		return SUBSTATUS_WARNING, "Registered, subscription could not be checked, packages from the unrestricted repository"
+	}
+	return SUBSTATUS_WARNING, "Registration mode unknown, install did not change the system"
+}
+
+// Status message of an enable that stopped before enabling the timer,
+// the substatus is named after the step that stopped it
+func _newEarlyEnableStatus(result string, step string, substatus string, message string) *EnableStatus {
+	status := &EnableStatus{Status: result, RegistrationMode: "unknown", Packages: map[string]string{}}
+	status.add(step, substatus, message)
+	return status
+}
+
+// Collect the state of the AHB components for the enable status
+func _getEnableStatus(host *Host, result string, ahbInfo AHBInfo, state *StateStore, manager SystemdManager) *EnableStatus {
+	status := &EnableStatus{Status: result, RegistrationMode: "unknown", Packages: map[string]string{}}
+
+	if state != nil && state.State.RegistrationMode != "" {
+		status.RegistrationMode = state.State.RegistrationMode
+	}
+	mode, message := _describeRegistrationMode(status.RegistrationMode)
+	status.add("registration", mode, message)
+
+	names := _getAhbPackageNames(ahbInfo)
+	installed, err := _getInstalledPackages(host, names...)
+	if err != nil {
+		status.add("packages", SUBSTATUS_ERROR, "Could not query the installed packages: "+err.Error())
+	} else {
+		missing := []string{}
+		for _, name := range names {
+			if pkg, found := installed[name]; found {
+				status.Packages[name] = pkg.EVR()
+			} else {
+				missing = append(missing, name)
+			}
+		}
+		regionSrv, found := installed[ahbInfo.RegionSrv]
+		switch {
+		case len(missing) > 0:
+			status.add("packages", SUBSTATUS_ERROR, "Not installed: "+strings.Join(missing, ", "))
+		case found && _compareEVR(regionSrv.EVR(), ahbInfo.RegionSrvMinVer) < 0:
+			status.add("packages", SUBSTATUS_ERROR,
+				fmt.Sprintf("%s %s is older than %s", ahbInfo.RegionSrv, regionSrv.EVR(), ahbInfo.RegionSrvMinVer))
+		default:
+			status.add("packages", SUBSTATUS_SUCCESS, "All AHB packages installed")
+		}
+	}
+
+	status.Timer.Name = ahbInfo.RegionSrvEnablerTimer
+	timerStatus, err := manager.Status(ahbInfo.RegionSrvEnablerTimer)
+	if err != nil {
+		status.add("timer", SUBSTATUS_ERROR, "Could not get the state of the timer: "+err.Error())
+		return status
+	}
+	status.Timer.Enabled = timerStatus.IsEnabled()
+	status.Timer.Active = timerStatus.IsActive()
+	status.Timer.NextTrigger = _formatTimestamp(timerStatus.NextElapse)
+	status.Timer.LastTrigger = _formatTimestamp(timerStatus.LastTrigger)
+	switch {
+	case !status.Timer.Enabled || !status.Timer.Active:
+		status.add("timer", SUBSTATUS_ERROR,
+			fmt.Sprintf("Timer %s is %s and %s", ahbInfo.RegionSrvEnablerTimer, timerStatus.UnitFileState, timerStatus.ActiveState))
+	case status.Timer.NextTrigger != "":
+		status.add("timer", SUBSTATUS_SUCCESS,
+			fmt.Sprintf("Timer %s is active, next trigger %s", ahbInfo.RegionSrvEnablerTimer, status.Timer.NextTrigger))
+	default:
+		status.add("timer", SUBSTATUS_SUCCESS, fmt.Sprintf("Timer %s is active", ahbInfo.RegionSrvEnablerTimer))
+	}
+	return status
+}
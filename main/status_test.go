@@ -0,0 +1,50 @@
+// Copyright (c) 2022, SUSE LLC, All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEarlyEnableStatus(t *testing.T) {
+	status := EnableStatus{}
+	message := _newEarlyEnableStatus("failure", "settings", SUBSTATUS_ERROR, "Invalid public settings").String()
+	require.NoError(t, json.Unmarshal([]byte(message), &status))
+	assert.Equal(t, "failure", status.Status)
+	assert.Equal(t, "unknown", status.RegistrationMode)
+	require.Len(t, status.Substatus, 1)
+	assert.Equal(t, SubStatus{
+		Name:             "settings",
+		Status:           SUBSTATUS_ERROR,
+		Code:             1,
+		FormattedMessage: FormattedMessage{Lang: "en-US", Message: "Invalid public settings"},
+	}, status.Substatus[0])
+
+	status = EnableStatus{}
+	message = _newEarlyEnableStatus("dry-run", "dryRun", SUBSTATUS_WARNING, "Dry run").String()
+	require.NoError(t, json.Unmarshal([]byte(message), &status))
+	require.Len(t, status.Substatus, 1)
+	assert.Equal(t, 0, status.Substatus[0].Code)
+}
@@ -0,0 +1,186 @@
+// Copyright (c) 2022, SUSE LLC, All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// SUSEConnect exits with this when the server can't be reached
+const SUSECONNECT_EXIT_CONNECTION_REFUSED = 64
+
+var (
+	ansiEscapePattern     = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+	extensionTripletRegex = regexp.MustCompile(`-p\s+(\S+)`)
+)
+
+// One entry of `SUSEConnect -s`
+type SUSEConnectProduct struct {
+	Identifier         string `json:"identifier"`
+	Version            string `json:"version"`
+	Arch               string `json:"arch"`
+	Status             string `json:"status"`
+	Regcode            string `json:"regcode"`
+	StartsAt           string `json:"starts_at"`
+	ExpiresAt          string `json:"expires_at"`
+	SubscriptionStatus string `json:"subscription_status"`
+	Type               string `json:"type"`
+}
+
+func (product SUSEConnectProduct) IsRegistered() bool {
+	return strings.ToLower(product.Status) == "registered"
+}
+
+func (product SUSEConnectProduct) Triplet() string {
+	return product.Identifier + "/" + product.Version + "/" + product.Arch
+}
+
+// Module or extension from `SUSEConnect --list-extensions`, Extensions
+// holds the ones that depend on it
+type SUSEConnectExtension struct {
+	Name         string
+	Triplet      string
+	Activated    bool
+	NotAvailable bool
+	NeedsRegcode bool
+	Extensions   []*SUSEConnectExtension
+}
+
+// Walk the extension tree depth first
+func _walkExtensions(extensions []*SUSEConnectExtension, visit func(extension *SUSEConnectExtension)) {
+	for _, extension := range extensions {
+		visit(extension)
+		_walkExtensions(extension.Extensions, visit)
+	}
+}
+
+// Runs SUSEConnect, against the given server when Url is set
+type SUSEConnectClient struct {
+	Url  string
+	host *Host
+}
+
+func newSUSEConnectClient(host *Host, rmt *RMTSettings) *SUSEConnectClient {
+	client := &SUSEConnectClient{host: host}
+	if rmt != nil {
+		client.Url = rmt.ServerUrl
+	}
+	return client
+}
+
+func (client *SUSEConnectClient) run(args ...string) (string, error) {
+	if client.Url != "" {
+		args = append([]string{"--url", client.Url}, args...)
+	}
+	return client.host.RunShellCommand(0, "SUSEConnect", args...)
+}
+
+// Status of every installed product
+func (client *SUSEConnectClient) Status() ([]SUSEConnectProduct, error) {
+	output, err := client.run("--status")
+	if err != nil {
+		return nil, err
+	}
+	var products []SUSEConnectProduct
+	if err = json.Unmarshal([]byte(output), &products); err != nil {
+		return nil, fmt.Errorf("Could not parse SUSEConnect status: %v", err)
+	}
+	return products, nil
+}
+
+func (client *SUSEConnectClient) ListExtensions() ([]*SUSEConnectExtension, error) {
+	output, err := client.run("--list-extensions")
+	if err != nil {
+		return nil, err
+	}
+	return _parseExtensionsList(output), nil
+}
+
+func (client *SUSEConnectClient) Activate(triplet string) error {
+	_, err := client.run("-p", triplet)
+	return err
+}
+
+func (client *SUSEConnectClient) Deactivate(triplet string) error {
+	_, err := client.run("-d", "-p", triplet)
+	return err
+}
+
+// Build the extension tree out of the human readable --list-extensions
+// output. Every entry is a name line followed by an "Activate with" or
+// "Deactivate with" line, the indentation of the name gives the nesting.
+func _parseExtensionsList(output string) []*SUSEConnectExtension {
+	type level struct {
+		indent    int
+		extension *SUSEConnectExtension
+	}
+	roots := []*SUSEConnectExtension{}
+	stack := []level{}
+	name, nameIndent := "", 0
+	for _, line := range strings.Split(ansiEscapePattern.ReplaceAllString(output, ""), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "REMARKS" {
+			break
+		}
+		if trimmed == "" || trimmed == "AVAILABLE EXTENSIONS AND MODULES" {
+			continue
+		}
+		isActivate := strings.HasPrefix(trimmed, "Activate with:")
+		isDeactivate := strings.HasPrefix(trimmed, "Deactivate with:")
+		if !isActivate && !isDeactivate {
+			name, nameIndent = trimmed, len(line)-len(strings.TrimLeft(line, " \t"))
+			continue
+		}
+		match := extensionTripletRegex.FindStringSubmatch(trimmed)
+		if name == "" || match == nil {
+			continue
+		}
+		extension := &SUSEConnectExtension{
+			Triplet:      match[1],
+			Activated:    isDeactivate,
+			NeedsRegcode: strings.Contains(trimmed, "-r "),
+		}
+		for _, marker := range []string{"(Activated)", "(Not available)"} {
+			if strings.Contains(name, marker) {
+				extension.Activated = extension.Activated || marker == "(Activated)"
+				extension.NotAvailable = extension.NotAvailable || marker == "(Not available)"
+				name = strings.Replace(name, marker, "", 1)
+			}
+		}
+		extension.Name = strings.TrimSpace(name)
+		name = ""
+
+		for len(stack) > 0 && stack[len(stack)-1].indent >= nameIndent {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) == 0 {
+			roots = append(roots, extension)
+		} else {
+			parent := stack[len(stack)-1].extension
+			parent.Extensions = append(parent.Extensions, extension)
+		}
+		stack = append(stack, level{indent: nameIndent, extension: extension})
+	}
+	return roots
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2022, SUSE LLC, All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseExtensionsList(t *testing.T) {
+	// recorded on SLES 15 SP4 with colors on
+	output, err := ioutil.ReadFile(filepath.Join("testdata", "suseconnect_list_extensions.txt"))
+	require.NoError(t, err)
+	extensions := _parseExtensionsList(string(output))
+
+	developmentTools := &SUSEConnectExtension{
+		Name:    "Development Tools Module 15 SP4 x86_64",
+		Triplet: "sle-module-development-tools/15.4/x86_64",
+	}
+	workstation := &SUSEConnectExtension{
+		Name:         "SUSE Linux Enterprise Workstation Extension 15 SP4 x86_64",
+		Triplet:      "sle-we/15.4/x86_64",
+		NeedsRegcode: true,
+	}
+	expected := []*SUSEConnectExtension{
+		{
+			Name:      "Basesystem Module 15 SP4 x86_64",
+			Triplet:   "sle-module-basesystem/15.4/x86_64",
+			Activated: true,
+			Extensions: []*SUSEConnectExtension{
+				{
+					Name:    "Containers Module 15 SP4 x86_64",
+					Triplet: "sle-module-containers/15.4/x86_64",
+				},
+				{
+					Name:       "Desktop Applications Module 15 SP4 x86_64",
+					Triplet:    "sle-module-desktop-applications/15.4/x86_64",
+					Activated:  true,
+					Extensions: []*SUSEConnectExtension{developmentTools, workstation},
+				},
+				{
+					Name:         "Public Cloud Module 15 SP4 x86_64",
+					Triplet:      "sle-module-public-cloud/15.4/x86_64",
+					NotAvailable: true,
+				},
+			},
+		},
+		{
+			Name:    "SUSE Package Hub 15 SP4 x86_64",
+			Triplet: "PackageHub/15.4/x86_64",
+		},
+	}
+	assert.Equal(t, expected, extensions)
+
+	// the legend below REMARKS is not taken for entries
+	activated := []string{}
+	_walkExtensions(extensions, func(extension *SUSEConnectExtension) {
+		if extension.Activated {
+			activated = append(activated, extension.Triplet)
+		}
+	})
+	assert.Equal(t, []string{"sle-module-basesystem/15.4/x86_64", "sle-module-desktop-applications/15.4/x86_64"}, activated)
+}
+
+func TestParseExtensionsListEmpty(t *testing.T) {
+	assert.Empty(t, _parseExtensionsList(""))
+	assert.Empty(t, _parseExtensionsList("AVAILABLE EXTENSIONS AND MODULES\n\nREMARKS\n"))
+	// nothing after REMARKS is an entry
+	assert.Empty(t, _parseExtensionsList("REMARKS\n\nSome Module\nActivate with: SUSEConnect -p some-module/15.4/x86_64\n"))
+}
+
+func TestRegistrationStatusOutput(t *testing.T) {
+	host := newTestHost(t, &ScriptedCommandRunner{})
+	var output bytes.Buffer
+	host.Output = &output
+	products := []SUSEConnectProduct{{
+		Identifier: "SLES", Version: "15.4", Arch: "x86_64",
+		Status: "Registered", SubscriptionStatus: "ACTIVE", ExpiresAt: "2027-01-01 00:00:00 UTC",
+	}}
+	registered, active, err := _getRegistrationStatus(host, products, &RMTSettings{ServerUrl: "https://rmt.invalid"}, "")
+	require.NoError(t, err)
+	assert.True(t, registered)
+	assert.True(t, active)
+	assert.Equal(t, "Product SLES/15.4/x86_64: status=Registered subscription=ACTIVE expires=2027-01-01 00:00:00 UTC\n", output.String())
+}
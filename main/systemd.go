@@ -0,0 +1,342 @@
+// Copyright (c) 2022, SUSE LLC, All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	systemd "github.com/coreos/go-systemd/v22/dbus"
+)
+
+const SYSTEMD_JOB_TIMEOUT = 120 * time.Second
+
+// systemctl show format of timestamps, e.g. "Mon 2022-08-01 10:00:00 UTC"
+const SYSTEMCTL_TIMESTAMP_FORMAT = "Mon 2006-01-02 15:04:05 MST"
+
+// State of a unit, the trigger times and the triggered unit are only set
+// for timers
+type UnitStatus struct {
+	Name          string    `json:"name"`
+	ActiveState   string    `json:"activeState"`
+	SubState      string    `json:"subState"`
+	UnitFileState string    `json:"unitFileState"`
+	LoadState     string    `json:"loadState"`
+	NextElapse    time.Time `json:"nextElapse"`
+	LastTrigger   time.Time `json:"lastTrigger"`
+	Unit          string    `json:"unit,omitempty"`
+}
+
+func (status UnitStatus) IsActive() bool {
+	return status.ActiveState == "active"
+}
+
+func (status UnitStatus) IsEnabled() bool {
+	return status.UnitFileState == "enabled"
+}
+
+// False for units systemd has no (valid) unit file for
+func (status UnitStatus) IsLoaded() bool {
+	return status.LoadState == "loaded"
+}
+
+// How the last run of a service ended. The monotonic exit timestamp and
+// the invocation ID tell one run from the next.
+type ServiceStatus struct {
+	Name                           string    `json:"name"`
+	ActiveState                    string    `json:"activeState"`
+	Result                         string    `json:"result"`
+	ExecMainStatus                 int       `json:"execMainStatus"`
+	ExecMainExitTimestamp          time.Time `json:"execMainExitTimestamp"`
+	ExecMainExitTimestampMonotonic uint64    `json:"execMainExitTimestampMonotonic"`
+	InvocationID                   string    `json:"invocationId,omitempty"`
+}
+
+// Unit management the extension needs from systemd. Start and stop wait
+// for their job to finish, StartNoWait queues the start job and sends
+// its result ("done", "failed", "dependency", ...) once it is over.
+type SystemdManager interface {
+	Enable(unit string) error
+	Disable(unit string) error
+	Start(unit string) error
+	StartNoWait(unit string) (<-chan string, error)
+	Stop(unit string) error
+	Status(unit string) (UnitStatus, error)
+	ServiceStatus(service string) (ServiceStatus, error)
+	Close()
+}
+
+// Talks to systemd on the system bus
+type DBusSystemdManager struct {
+	conn    *systemd.Conn
+	Timeout time.Duration
+}
+
+func newDBusSystemdManager() (*DBusSystemdManager, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), SYSTEMD_JOB_TIMEOUT)
+	defer cancel()
+	conn, err := systemd.NewSystemConnectionContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &DBusSystemdManager{conn: conn, Timeout: SYSTEMD_JOB_TIMEOUT}, nil
+}
+
+func (manager *DBusSystemdManager) Enable(unit string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), manager.Timeout)
+	defer cancel()
+	if _, _, err := manager.conn.EnableUnitFilesContext(ctx, []string{unit}, false, false); err != nil {
+		return fmt.Errorf("Error enabling %s: %v", unit, err)
+	}
+	return manager.conn.ReloadContext(ctx)
+}
+
+func (manager *DBusSystemdManager) Disable(unit string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), manager.Timeout)
+	defer cancel()
+	if _, err := manager.conn.DisableUnitFilesContext(ctx, []string{unit}, false); err != nil {
+		return fmt.Errorf("Error disabling %s: %v", unit, err)
+	}
+	return manager.conn.ReloadContext(ctx)
+}
+
+type systemdJobFunc func(ctx context.Context, name string, mode string, ch chan<- string) (int, error)
+
+// Queue the job and wait for its result, anything but done is an error
+func (manager *DBusSystemdManager) runJob(action string, job systemdJobFunc, unit string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), manager.Timeout)
+	defer cancel()
+	result := make(chan string, 1)
+	if _, err := job(ctx, unit, "replace", result); err != nil {
+		return fmt.Errorf("Error trying to %s %s: %v", action, unit, err)
+	}
+	select {
+	case done := <-result:
+		if done != "done" {
+			return fmt.Errorf("Job to %s %s finished with result '%s'", action, unit, done)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("Timeout waiting for the job to %s %s", action, unit)
+	}
+}
+
+func (manager *DBusSystemdManager) Start(unit string) error {
+	return manager.runJob("start", manager.conn.StartUnitContext, unit)
+}
+
+func (manager *DBusSystemdManager) StartNoWait(unit string) (<-chan string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), manager.Timeout)
+	defer cancel()
+	// the result comes with the JobRemoved signal, not the call
+	result := make(chan string, 1)
+	if _, err := manager.conn.StartUnitContext(ctx, unit, "replace", result); err != nil {
+		return nil, fmt.Errorf("Error trying to start %s: %v", unit, err)
+	}
+	return result, nil
+}
+
+func (manager *DBusSystemdManager) Stop(unit string) error {
+	return manager.runJob("stop", manager.conn.StopUnitContext, unit)
+}
+
+// systemd reports timestamps in microseconds since the epoch, 0 for never
+func _usecTimestamp(value interface{}) time.Time {
+	usec, ok := value.(uint64)
+	if !ok || usec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, int64(usec)*int64(time.Microsecond))
+}
+
+func (manager *DBusSystemdManager) Status(unit string) (UnitStatus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), manager.Timeout)
+	defer cancel()
+	status := UnitStatus{Name: unit}
+	properties, err := manager.conn.GetUnitPropertiesContext(ctx, unit)
+	if err != nil {
+		return status, err
+	}
+	status.ActiveState, _ = properties["ActiveState"].(string)
+	status.SubState, _ = properties["SubState"].(string)
+	status.UnitFileState, _ = properties["UnitFileState"].(string)
+	status.LoadState, _ = properties["LoadState"].(string)
+	if !strings.HasSuffix(unit, ".timer") || !status.IsLoaded() {
+		return status, nil
+	}
+	properties, err = manager.conn.GetUnitTypePropertiesContext(ctx, unit, "Timer")
+	if err != nil {
+		return status, err
+	}
+	status.NextElapse = _usecTimestamp(properties["NextElapseUSecRealtime"])
+	status.LastTrigger = _usecTimestamp(properties["LastTriggerUSec"])
+	status.Unit, _ = properties["Unit"].(string)
+	return status, nil
+}
+
+func (manager *DBusSystemdManager) ServiceStatus(service string) (ServiceStatus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), manager.Timeout)
+	defer cancel()
+	status := ServiceStatus{Name: service}
+	properties, err := manager.conn.GetUnitPropertiesContext(ctx, service)
+	if err != nil {
+		return status, err
+	}
+	status.ActiveState, _ = properties["ActiveState"].(string)
+	if invocationId, ok := properties["InvocationID"].([]byte); ok {
+		status.InvocationID = hex.EncodeToString(invocationId)
+	}
+	properties, err = manager.conn.GetUnitTypePropertiesContext(ctx, service, "Service")
+	if err != nil {
+		return status, err
+	}
+	status.Result, _ = properties["Result"].(string)
+	if exitStatus, ok := properties["ExecMainStatus"].(int32); ok {
+		status.ExecMainStatus = int(exitStatus)
+	}
+	status.ExecMainExitTimestamp = _usecTimestamp(properties["ExecMainExitTimestamp"])
+	status.ExecMainExitTimestampMonotonic, _ = properties["ExecMainExitTimestampMonotonic"].(uint64)
+	return status, nil
+}
+
+func (manager *DBusSystemdManager) Close() {
+	manager.conn.Close()
+}
+
+// Fallback for when the system bus can't be reached, systemctl start
+// and stop wait for their job by default
+type SystemctlManager struct {
+	host *Host
+}
+
+func (manager *SystemctlManager) run(action string, unit string) error {
+	_, err := manager.host.RunShellCommand(0, "systemctl", action, unit)
+	return err
+}
+
+func (manager *SystemctlManager) Enable(unit string) error  { return manager.run("enable", unit) }
+func (manager *SystemctlManager) Disable(unit string) error { return manager.run("disable", unit) }
+func (manager *SystemctlManager) Start(unit string) error   { return manager.run("start", unit) }
+func (manager *SystemctlManager) Stop(unit string) error    { return manager.run("stop", unit) }
+
+// systemctl start --no-block does not tell how the job ended, a waiting
+// systemctl start does, so it is run in the background
+func (manager *SystemctlManager) StartNoWait(unit string) (<-chan string, error) {
+	result := make(chan string, 1)
+	go func() {
+		_, err := manager.host.RunShellCommand(SYSTEMD_JOB_TIMEOUT, "systemctl", "start", unit)
+		var commandError *CommandError
+		switch {
+		case err == nil:
+			result <- "done"
+		case errors.As(err, &commandError) && commandError.TimedOut:
+			result <- "timeout"
+		default:
+			result <- "failed"
+		}
+	}()
+	return result, nil
+}
+
+func _systemctlTimestamp(value string) time.Time {
+	timestamp, err := time.Parse(SYSTEMCTL_TIMESTAMP_FORMAT, value)
+	if err != nil {
+		return time.Time{}
+	}
+	return timestamp
+}
+
+func (manager *SystemctlManager) Status(unit string) (UnitStatus, error) {
+	status := UnitStatus{Name: unit}
+	properties := []string{"ActiveState", "SubState", "UnitFileState", "LoadState"}
+	if strings.HasSuffix(unit, ".timer") {
+		properties = append(properties, "NextElapseUSecRealtime", "LastTriggerUSec", "Unit")
+	}
+	values, err := _getUnitProperties(manager.host, unit, properties...)
+	if err != nil {
+		return status, err
+	}
+	status.ActiveState = values["ActiveState"]
+	status.SubState = values["SubState"]
+	status.UnitFileState = values["UnitFileState"]
+	status.LoadState = values["LoadState"]
+	status.NextElapse = _systemctlTimestamp(values["NextElapseUSecRealtime"])
+	status.LastTrigger = _systemctlTimestamp(values["LastTriggerUSec"])
+	status.Unit = values["Unit"]
+	return status, nil
+}
+
+func (manager *SystemctlManager) ServiceStatus(service string) (ServiceStatus, error) {
+	status := ServiceStatus{Name: service}
+	values, err := _getUnitProperties(manager.host, service, "ActiveState", "Result", "ExecMainStatus",
+		"ExecMainExitTimestamp", "ExecMainExitTimestampMonotonic", "InvocationID")
+	if err != nil {
+		return status, err
+	}
+	status.ActiveState = values["ActiveState"]
+	status.Result = values["Result"]
+	status.ExecMainStatus, _ = strconv.Atoi(values["ExecMainStatus"])
+	status.ExecMainExitTimestamp = _systemctlTimestamp(values["ExecMainExitTimestamp"])
+	status.ExecMainExitTimestampMonotonic, _ = strconv.ParseUint(values["ExecMainExitTimestampMonotonic"], 10, 64)
+	status.InvocationID = values["InvocationID"]
+	return status, nil
+}
+
+func (manager *SystemctlManager) Close() {}
+
+// Enable the timer and start it right away
+func _enableTimer(manager SystemdManager, timer string) error {
+	if err := manager.Enable(timer); err != nil {
+		return err
+	}
+	return manager.Start(timer)
+}
+
+func _disableTimer(manager SystemdManager, timer string) error {
+	if err := manager.Stop(timer); err != nil {
+		return err
+	}
+	return manager.Disable(timer)
+}
+
+// Empty for timestamps that are not set
+func _formatTimestamp(timestamp time.Time) string {
+	if timestamp.IsZero() {
+		return ""
+	}
+	return timestamp.UTC().Format(time.RFC3339)
+}
+
+// D-Bus when the system bus is there, systemctl otherwise
+func newSystemdManager(host *Host) SystemdManager {
+	manager, err := newDBusSystemdManager()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Could not connect to systemd over D-Bus, using systemctl:", err)
+		return &SystemctlManager{host: host}
+	}
+	return manager
+}
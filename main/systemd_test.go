@@ -0,0 +1,329 @@
+// Copyright (c) 2022, SUSE LLC, All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// SystemdManager keeping units in memory. onStart is called by both
+// starts and returns the job result, it may change the units.
+type fakeSystemdManager struct {
+	units    map[string]UnitStatus
+	services map[string]ServiceStatus
+	failures map[string]error
+	onStart  func(manager *fakeSystemdManager, unit string) string
+	calls    []string
+}
+
+func newFakeSystemdManager() *fakeSystemdManager {
+	return &fakeSystemdManager{
+		units:    map[string]UnitStatus{},
+		services: map[string]ServiceStatus{},
+		failures: map[string]error{},
+	}
+}
+
+func (manager *fakeSystemdManager) call(action string, unit string) error {
+	manager.calls = append(manager.calls, action+" "+unit)
+	return manager.failures[action+" "+unit]
+}
+
+func (manager *fakeSystemdManager) Enable(unit string) error {
+	if err := manager.call("enable", unit); err != nil {
+		return err
+	}
+	status := manager.units[unit]
+	status.Name, status.UnitFileState = unit, "enabled"
+	manager.units[unit] = status
+	return nil
+}
+
+func (manager *fakeSystemdManager) Disable(unit string) error {
+	if err := manager.call("disable", unit); err != nil {
+		return err
+	}
+	status := manager.units[unit]
+	status.Name, status.UnitFileState = unit, "disabled"
+	manager.units[unit] = status
+	return nil
+}
+
+func (manager *fakeSystemdManager) start(unit string) string {
+	if manager.onStart != nil {
+		return manager.onStart(manager, unit)
+	}
+	status := manager.units[unit]
+	status.Name, status.ActiveState = unit, "active"
+	manager.units[unit] = status
+	return "done"
+}
+
+func (manager *fakeSystemdManager) Start(unit string) error {
+	if err := manager.call("start", unit); err != nil {
+		return err
+	}
+	if result := manager.start(unit); result != "done" {
+		return fmt.Errorf("Job to start %s finished with result '%s'", unit, result)
+	}
+	return nil
+}
+
+func (manager *fakeSystemdManager) StartNoWait(unit string) (<-chan string, error) {
+	if err := manager.call("start --no-block", unit); err != nil {
+		return nil, err
+	}
+	result := make(chan string, 1)
+	result <- manager.start(unit)
+	return result, nil
+}
+
+func (manager *fakeSystemdManager) Stop(unit string) error {
+	if err := manager.call("stop", unit); err != nil {
+		return err
+	}
+	status := manager.units[unit]
+	status.Name, status.ActiveState = unit, "inactive"
+	manager.units[unit] = status
+	return nil
+}
+
+func (manager *fakeSystemdManager) Status(unit string) (UnitStatus, error) {
+	if err := manager.failures["status "+unit]; err != nil {
+		return UnitStatus{Name: unit}, err
+	}
+	status, found := manager.units[unit]
+	if !found {
+		return UnitStatus{Name: unit, ActiveState: "inactive", LoadState: "not-found"}, nil
+	}
+	if status.LoadState == "" {
+		status.LoadState = "loaded"
+	}
+	return status, nil
+}
+
+func (manager *fakeSystemdManager) ServiceStatus(service string) (ServiceStatus, error) {
+	if err := manager.failures["status "+service]; err != nil {
+		return ServiceStatus{Name: service}, err
+	}
+	status, found := manager.services[service]
+	if !found {
+		return ServiceStatus{Name: service, ActiveState: "inactive"}, nil
+	}
+	return status, nil
+}
+
+func (manager *fakeSystemdManager) Close() {}
+
+const (
+	testTimer   = "regionsrv-enabler-azure.timer"
+	testService = "regionsrv-enabler-azure.service"
+)
+
+// Enabled and active timer whose service last ran successfully
+func newFakeEnablerManager() *fakeSystemdManager {
+	manager := newFakeSystemdManager()
+	manager.units[testTimer] = UnitStatus{
+		Name:          testTimer,
+		ActiveState:   "active",
+		UnitFileState: "enabled",
+		LastTrigger:   time.Date(2022, 8, 1, 10, 0, 0, 0, time.UTC),
+		NextElapse:    time.Date(2022, 8, 1, 11, 0, 0, 0, time.UTC),
+		Unit:          testService,
+	}
+	manager.services[testService] = ServiceStatus{
+		Name:                           testService,
+		ActiveState:                    "inactive",
+		Result:                         "success",
+		ExecMainExitTimestamp:          time.Date(2022, 8, 1, 10, 0, 5, 0, time.UTC),
+		ExecMainExitTimestampMonotonic: 1000,
+		InvocationID:                   "0123456789abcdef0123456789abcdef",
+	}
+	return manager
+}
+
+// A start that runs the service to the end with the given outcome
+func runService(result string, exitStatus int) func(*fakeSystemdManager, string) string {
+	return func(manager *fakeSystemdManager, unit string) string {
+		status := manager.services[unit]
+		status.ActiveState = "inactive"
+		if result != "success" {
+			status.ActiveState = "failed"
+		}
+		status.Result = result
+		status.ExecMainStatus = exitStatus
+		status.ExecMainExitTimestampMonotonic += 1000
+		status.InvocationID = "fedcba9876543210fedcba9876543210"
+		manager.services[unit] = status
+		if result != "success" {
+			return "failed"
+		}
+		return "done"
+	}
+}
+
+func TestEnableAndDisableTimer(t *testing.T) {
+	manager := newFakeSystemdManager()
+	require.NoError(t, _enableTimer(manager, testTimer))
+	status, err := manager.Status(testTimer)
+	require.NoError(t, err)
+	assert.True(t, status.IsEnabled())
+	assert.True(t, status.IsActive())
+
+	require.NoError(t, _disableTimer(manager, testTimer))
+	status, err = manager.Status(testTimer)
+	require.NoError(t, err)
+	assert.False(t, status.IsEnabled())
+	assert.False(t, status.IsActive())
+	assert.Equal(t, []string{
+		"enable " + testTimer, "start " + testTimer,
+		"stop " + testTimer, "disable " + testTimer,
+	}, manager.calls)
+}
+
+func TestDisableEnablerTimer(t *testing.T) {
+	manager := newFakeEnablerManager()
+	require.NoError(t, _disableEnablerTimer(manager, testTimer))
+	assert.Equal(t, []string{"stop " + testTimer, "disable " + testTimer}, manager.calls)
+
+	manager = newFakeEnablerManager()
+	manager.failures["stop "+testTimer] = errors.New("access denied")
+	assert.Error(t, _disableEnablerTimer(manager, testTimer))
+}
+
+func TestDisableEnablerTimerNotLoaded(t *testing.T) {
+	// install failed, systemd does not know the timer
+	manager := newFakeSystemdManager()
+	manager.failures["stop "+testTimer] = errors.New("Unit regionsrv-enabler-azure.timer not loaded.")
+	require.NoError(t, _disableEnablerTimer(manager, testTimer))
+	assert.Empty(t, manager.calls)
+}
+
+func TestEnableTimerStopsOnError(t *testing.T) {
+	manager := newFakeSystemdManager()
+	manager.failures["enable "+testTimer] = errors.New("unit not found")
+	assert.Error(t, _enableTimer(manager, testTimer))
+	assert.Equal(t, []string{"enable " + testTimer}, manager.calls)
+}
+
+func TestVerifyEnablerRunSucceeds(t *testing.T) {
+	manager := newFakeEnablerManager()
+	manager.onStart = runService("success", 0)
+	require.NoError(t, _verifyEnablerRun(newTestHost(t, &ScriptedCommandRunner{}), manager, testTimer, time.Minute))
+	assert.Equal(t, []string{"start --no-block " + testService}, manager.calls)
+}
+
+func TestVerifyEnablerRunFails(t *testing.T) {
+	manager := newFakeEnablerManager()
+	manager.onStart = runService("exit-code", 1)
+	runner := &ScriptedCommandRunner{Commands: []ScriptedCommand{{
+		Name:   "journalctl",
+		Args:   []string{"--no-pager", "-o", "cat", "-n", "20", "_SYSTEMD_INVOCATION_ID=fedcba9876543210fedcba9876543210"},
+		Result: CommandResult{Stdout: "Starting regionsrv-enabler-azure...\nregistercloudguest failed\n"},
+	}}}
+	err := _verifyEnablerRun(newTestHost(t, runner), manager, testTimer, time.Minute)
+	var runError *EnablerRunError
+	require.True(t, errors.As(err, &runError), "%v", err)
+	assert.Equal(t, testService, runError.Service)
+	assert.Equal(t, "exit-code", runError.Result)
+	assert.Equal(t, 1, runError.ExitStatus)
+	assert.Equal(t, []string{"Starting regionsrv-enabler-azure...", "registercloudguest failed"}, runError.Journal)
+	assert.True(t, runner.Done())
+}
+
+func TestVerifyEnablerRunFailsFastWhenServiceDoesNotRun(t *testing.T) {
+	manager := newFakeEnablerManager()
+	// a failed dependency, the service is never invoked
+	manager.onStart = func(*fakeSystemdManager, string) string { return "dependency" }
+	start := time.Now()
+	err := _verifyEnablerRun(newTestHost(t, &ScriptedCommandRunner{}), manager, testTimer, time.Minute)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "did not run, start job result 'dependency'")
+	assert.True(t, time.Since(start) < ENABLER_VERIFY_POLL_INTERVAL)
+}
+
+func TestVerifyEnablerRunTimesOut(t *testing.T) {
+	manager := newFakeEnablerManager()
+	manager.onStart = func(manager *fakeSystemdManager, unit string) string {
+		status := manager.services[unit]
+		status.ActiveState = "activating"
+		status.InvocationID = "fedcba9876543210fedcba9876543210"
+		manager.services[unit] = status
+		return "done"
+	}
+	err := _verifyEnablerRun(newTestHost(t, &ScriptedCommandRunner{}), manager, testTimer, time.Nanosecond)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "did not finish")
+}
+
+func TestVerifyEnablerRunWithoutService(t *testing.T) {
+	manager := newFakeSystemdManager()
+	manager.units[testTimer] = UnitStatus{Name: testTimer, ActiveState: "active", UnitFileState: "enabled"}
+	assert.Error(t, _verifyEnablerRun(newTestHost(t, &ScriptedCommandRunner{}), manager, testTimer, time.Minute))
+	assert.Empty(t, manager.calls)
+}
+
+func TestEnablerHealth(t *testing.T) {
+	manager := newFakeEnablerManager()
+	health, err := _getEnablerHealth(manager, testTimer)
+	require.NoError(t, err)
+	assert.Equal(t, EnablerHealth{
+		Timer:         testTimer,
+		TimerActive:   true,
+		LastTrigger:   "2022-08-01T10:00:00Z",
+		Service:       testService,
+		LastRunResult: "success",
+		LastRunExited: "2022-08-01T10:00:05Z",
+	}, health)
+	assert.Equal(t, HEARTBEAT_READY, _newHeartbeat(health, err).Status)
+
+	runService("exit-code", 1)(manager, testService)
+	health, err = _getEnablerHealth(manager, testTimer)
+	require.NoError(t, err)
+	assert.Equal(t, HEARTBEAT_NOT_READY, _newHeartbeat(health, err).Status)
+
+	manager.failures["status "+testTimer] = errors.New("no such unit")
+	health, err = _getEnablerHealth(manager, testTimer)
+	assert.Equal(t, HEARTBEAT_NOT_READY, _newHeartbeat(health, err).Status)
+}
+
+func TestEnablerHealthNeverRan(t *testing.T) {
+	manager := newFakeEnablerManager()
+	manager.services[testService] = ServiceStatus{Name: testService, ActiveState: "inactive"}
+	health, err := _getEnablerHealth(manager, testTimer)
+	require.NoError(t, err)
+	assert.Equal(t, "", health.LastRunExited)
+	// not having run yet is fine right after enable
+	assert.Equal(t, HEARTBEAT_READY, _newHeartbeat(health, err).Status)
+}
+
+func TestSystemctlTimestamp(t *testing.T) {
+	assert.Equal(t, time.Date(2022, 8, 1, 10, 0, 0, 0, time.UTC),
+		_systemctlTimestamp("Mon 2022-08-01 10:00:00 UTC").UTC())
+	assert.True(t, _systemctlTimestamp("n/a").IsZero())
+	assert.True(t, _systemctlTimestamp("").IsZero())
+}
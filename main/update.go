@@ -0,0 +1,159 @@
+// Copyright (c) 2022, SUSE LLC, All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Set by the guest agent when calling the update command of the new version
+const UPDATING_FROM_VERSION_ENV = "AZURE_GUEST_AGENT_UPDATING_FROM_VERSION"
+
+// A step run on update for any previous extension version older than
+// Before, an empty Before applies to every previous version. Steps are
+// also run when the previous version is unknown, so they check the VM
+// first and do nothing when it is already in shape.
+type extensionMigration struct {
+	Before      string
+	Description string
+	Apply       func(host *Host, settings PublicSettings, rmt *RMTSettings, events EventLogger, state *StateStore) error
+}
+
+var extensionMigrations = []extensionMigration{
+	{
+		Description: "Upgrade cloud-regionsrv-client to the minimum version",
+		Apply:       _migrateRegionSrvVersion,
+	},
+	{
+		// 0.0.0.3 kept no state and its disable left the timer alone
+		Before:      "0.0.0.4",
+		Description: "Record whether the enabler timer was enabled",
+		Apply:       _migrateEnabledByUser,
+	},
+	{
+		Description: "Restore the enabler timer",
+		Apply:       _migrateEnablerTimer,
+	},
+}
+
+// Compare two dotted numeric extension versions, missing parts count as 0
+func _compareExtensionVersions(first string, second string) int {
+	firstParts := strings.Split(first, ".")
+	secondParts := strings.Split(second, ".")
+	for i := 0; i < len(firstParts) || i < len(secondParts); i++ {
+		firstPart, secondPart := 0, 0
+		if i < len(firstParts) {
+			firstPart, _ = strconv.Atoi(firstParts[i])
+		}
+		if i < len(secondParts) {
+			secondPart, _ = strconv.Atoi(secondParts[i])
+		}
+		if firstPart != secondPart {
+			if firstPart < secondPart {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// The version recorded in the state journal, falling back to what the
+// guest agent reports for versions that did not keep a journal.
+// An empty string means the previous version is unknown.
+func _getPreviousExtensionVersion(state *StateStore) string {
+	if state.State.ExtensionVersion != "" {
+		return state.State.ExtensionVersion
+	}
+	return os.Getenv(UPDATING_FROM_VERSION_ENV)
+}
+
+func _migrateRegionSrvVersion(host *Host, settings PublicSettings, rmt *RMTSettings, events EventLogger, state *StateStore) error {
+	ahbInfo := settings.AHBInfo
+	if _checkVersion(host, ahbInfo) {
+		return nil
+	}
+	fmt.Println("Installed", ahbInfo.RegionSrv, "is older than", ahbInfo.RegionSrvMinVer)
+	return _handlePackageInstall(host, settings, rmt, events, state)
+}
+
+// Versions without a journal left no EnabledByUser behind, the timer
+// state in systemd is all there is
+func _migrateEnabledByUser(host *Host, settings PublicSettings, rmt *RMTSettings, events EventLogger, state *StateStore) error {
+	if state.State.EnabledByUser {
+		return nil
+	}
+	timer := settings.AHBInfo.RegionSrvEnablerTimer
+	manager := host.systemdManager()
+	defer manager.Close()
+	status, err := manager.Status(timer)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Error getting the state of timer", timer+":", err)
+		return nil
+	}
+	if status.IsEnabled() || status.IsActive() {
+		return state.enabledByUser(true)
+	}
+	return nil
+}
+
+// Disable runs before update and stops the timer, EnabledByUser says
+// whether it was enabled before that
+func _migrateEnablerTimer(host *Host, settings PublicSettings, rmt *RMTSettings, events EventLogger, state *StateStore) error {
+	if !state.State.EnabledByUser {
+		return nil
+	}
+	ahbInfo := settings.AHBInfo
+	manager := host.systemdManager()
+	defer manager.Close()
+	previousTimer := state.State.Timer.Name
+	if previousTimer != "" && previousTimer != ahbInfo.RegionSrvEnablerTimer {
+		// the timer got renamed, stop the old one
+		if err := _disableTimer(manager, previousTimer); err != nil {
+			fmt.Fprintln(os.Stderr, "Error when trying to disable timer", previousTimer+":", err)
+		}
+	}
+	if err := _enableTimer(manager, ahbInfo.RegionSrvEnablerTimer); err != nil {
+		fmt.Fprintln(os.Stderr, "Error when trying to enable timer", ahbInfo.RegionSrvEnablerTimer+":", err)
+		return err
+	}
+	return _updateTimerState(state, manager, ahbInfo.RegionSrvEnablerTimer)
+}
+
+func _runExtensionMigrations(host *Host, settings PublicSettings, rmt *RMTSettings, events EventLogger, state *StateStore, previousVersion string) error {
+	for _, migration := range extensionMigrations {
+		if migration.Before != "" && previousVersion != "" &&
+			_compareExtensionVersions(previousVersion, migration.Before) >= 0 {
+			continue
+		}
+		fmt.Println("Running update step:", migration.Description)
+		if err := migration.Apply(host, settings, rmt, events, state); err != nil {
+			events.LogErrorEvent(
+				UPDATE_EVENT,
+				fmt.Sprintf(OPERATION_FAILURE_MSG, migration.Description, err.Error()))
+			return err
+		}
+	}
+	return nil
+}
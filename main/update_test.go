@@ -0,0 +1,84 @@
+// Copyright (c) 2022, SUSE LLC, All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Host with the AHB packages installed and systemd replaced by manager
+func newTestUpdateHost(t *testing.T, manager *fakeSystemdManager) *Host {
+	host := newTestHost(t, loadScriptedCommandRunner(t, "update_installed"))
+	host.connectSystemd = func(*Host) SystemdManager {
+		return manager
+	}
+	return host
+}
+
+func TestUpdateRestoresTimerStoppedByDisable(t *testing.T) {
+	manager := newFakeSystemdManager()
+	manager.units[testTimer] = UnitStatus{Name: testTimer, ActiveState: "inactive", UnitFileState: "disabled"}
+	host := newTestUpdateHost(t, manager)
+	state, err := _loadState(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, state.enabledByUser(true))
+	// what the disable of the previous version left behind
+	require.NoError(t, state.timerState(testTimer, false, false))
+
+	events := &recordingEventLogger{}
+	settings := PublicSettings{AHBInfo: getAhbInfo()}
+	require.NoError(t, _runExtensionMigrations(host, settings, nil, events, state, "0.0.0.4"))
+	assert.Equal(t, []string{"enable " + testTimer, "start " + testTimer}, manager.calls)
+	assert.Equal(t, TimerState{Name: testTimer, Enabled: true, Active: true}, state.State.Timer)
+	assert.True(t, state.State.EnabledByUser)
+	assert.Empty(t, events.errors)
+}
+
+func TestUpdateLeavesTimerNotEnabledByUser(t *testing.T) {
+	manager := newFakeSystemdManager()
+	manager.units[testTimer] = UnitStatus{Name: testTimer, ActiveState: "active", UnitFileState: "enabled"}
+	host := newTestUpdateHost(t, manager)
+	state, err := _loadState(t.TempDir())
+	require.NoError(t, err)
+
+	// the journal is there, systemd is not asked about the timer
+	settings := PublicSettings{AHBInfo: getAhbInfo()}
+	require.NoError(t, _runExtensionMigrations(host, settings, nil, &recordingEventLogger{}, state, "0.0.0.4"))
+	assert.Empty(t, manager.calls)
+	assert.False(t, state.State.EnabledByUser)
+}
+
+func TestUpdateFromVersionWithoutJournal(t *testing.T) {
+	manager := newFakeSystemdManager()
+	manager.units[testTimer] = UnitStatus{Name: testTimer, ActiveState: "active", UnitFileState: "enabled"}
+	host := newTestUpdateHost(t, manager)
+	state, err := _loadState(t.TempDir())
+	require.NoError(t, err)
+
+	settings := PublicSettings{AHBInfo: getAhbInfo()}
+	require.NoError(t, _runExtensionMigrations(host, settings, nil, &recordingEventLogger{}, state, "0.0.0.3"))
+	assert.True(t, state.State.EnabledByUser)
+	assert.Equal(t, []string{"enable " + testTimer, "start " + testTimer}, manager.calls)
+	assert.Equal(t, TimerState{Name: testTimer, Enabled: true, Active: true}, state.State.Timer)
+}
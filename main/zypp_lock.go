@@ -0,0 +1,94 @@
+// Copyright (c) 2022, SUSE LLC, All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ZYPP_LOCK_FILE          = "/run/zypp.pid"
+	ZYPP_LOCK_FIRST_BACKOFF = 5 * time.Second
+	ZYPP_LOCK_MAX_BACKOFF   = 60 * time.Second
+	// for all the zypper calls of a callback together, the guest
+	// agent kills commands that take longer than 5 minutes
+	ZYPP_LOCK_MAX_WAIT = 90 * time.Second
+)
+
+// Process holding the zypp lock, Pid is 0 when it can't be told
+type ZyppLockHolder struct {
+	Pid     int
+	Command string
+}
+
+func (holder ZyppLockHolder) String() string {
+	if holder.Pid == 0 {
+		return "an unknown process"
+	}
+	if holder.Command == "" {
+		return fmt.Sprintf("PID %d", holder.Pid)
+	}
+	return fmt.Sprintf("PID %d (%s)", holder.Pid, holder.Command)
+}
+
+func _zyppLockWaitMessage(holder ZyppLockHolder, wait time.Duration) string {
+	return fmt.Sprintf("zypp is locked by %s, retrying in %s", holder, wait)
+}
+
+func _getZyppLockHolder(lockFile string, procDir string) ZyppLockHolder {
+	holder := ZyppLockHolder{}
+	content, err := ioutil.ReadFile(lockFile)
+	if err != nil {
+		return holder
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil || pid <= 0 {
+		return holder
+	}
+	holder.Pid = pid
+	cmdline, err := ioutil.ReadFile(filepath.Join(procDir, strconv.Itoa(pid), "cmdline"))
+	if err == nil && len(cmdline) > 0 {
+		holder.Command = strings.TrimSpace(strings.ReplaceAll(string(cmdline), "\x00", " "))
+		return holder
+	}
+	comm, err := ioutil.ReadFile(filepath.Join(procDir, strconv.Itoa(pid), "comm"))
+	if err == nil {
+		holder.Command = strings.TrimSpace(string(comm))
+	}
+	return holder
+}
+
+// Backoff for the given retry, doubled every time up to the maximum
+func _zyppLockBackoff(retry int) time.Duration {
+	backoff := ZYPP_LOCK_FIRST_BACKOFF
+	for i := 0; i < retry && backoff < ZYPP_LOCK_MAX_BACKOFF; i++ {
+		backoff *= 2
+	}
+	if backoff > ZYPP_LOCK_MAX_BACKOFF {
+		backoff = ZYPP_LOCK_MAX_BACKOFF
+	}
+	return backoff
+}
@@ -0,0 +1,274 @@
+// Copyright (c) 2022, SUSE LLC, All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// zypper exit codes, see zypper(8)
+const (
+	ZYPPER_EXIT_OK                    = 0
+	ZYPPER_EXIT_ERR_BUG               = 1
+	ZYPPER_EXIT_ERR_SYNTAX            = 2
+	ZYPPER_EXIT_ERR_INVALID_ARGS      = 3
+	ZYPPER_EXIT_ERR_ZYPP              = 4
+	ZYPPER_EXIT_ERR_PRIVILEGES        = 5
+	ZYPPER_EXIT_NO_REPOS              = 6
+	ZYPPER_EXIT_ZYPP_LOCKED           = 7
+	ZYPPER_EXIT_ERR_COMMIT            = 8
+	ZYPPER_EXIT_INF_UPDATE_NEEDED     = 100
+	ZYPPER_EXIT_INF_SEC_UPDATE_NEEDED = 101
+	ZYPPER_EXIT_INF_REBOOT_NEEDED     = 102
+	ZYPPER_EXIT_INF_RESTART_NEEDED    = 103
+	ZYPPER_EXIT_INF_CAP_NOT_FOUND     = 104
+	ZYPPER_EXIT_ON_SIGNAL             = 105
+	ZYPPER_EXIT_INF_REPOS_SKIPPED     = 106
+	ZYPPER_EXIT_INF_RPM_SCRIPT_FAILED = 107
+)
+
+var (
+	ErrZypper             = errors.New("zypper failed")
+	ErrZypperInvalidArgs  = errors.New("invalid zypper arguments")
+	ErrZypperPrivileges   = errors.New("zypper needs root privileges")
+	ErrZypperLocked       = errors.New("zypp is locked by another process")
+	ErrZypperRepo         = errors.New("repository error")
+	ErrZypperCommit       = errors.New("package transaction failed")
+	ErrZypperCapNotFound  = errors.New("package not found")
+	ErrZypperRebootNeeded = errors.New("reboot needed")
+	// zypper updated itself, the command has to be run again
+	ErrZypperRestartNeeded = errors.New("zypper needs to be run again")
+	ErrZypperInterrupted   = errors.New("zypper was interrupted")
+	ErrZypperRpmScript     = errors.New("rpm scriptlet failed")
+)
+
+var zypperExitErrors = map[int]error{
+	ZYPPER_EXIT_ERR_BUG:               ErrZypper,
+	ZYPPER_EXIT_ERR_SYNTAX:            ErrZypperInvalidArgs,
+	ZYPPER_EXIT_ERR_INVALID_ARGS:      ErrZypperInvalidArgs,
+	ZYPPER_EXIT_ERR_ZYPP:              ErrZypper,
+	ZYPPER_EXIT_ERR_PRIVILEGES:        ErrZypperPrivileges,
+	ZYPPER_EXIT_NO_REPOS:              ErrZypperRepo,
+	ZYPPER_EXIT_ZYPP_LOCKED:           ErrZypperLocked,
+	ZYPPER_EXIT_ERR_COMMIT:            ErrZypperCommit,
+	ZYPPER_EXIT_INF_REBOOT_NEEDED:     ErrZypperRebootNeeded,
+	ZYPPER_EXIT_INF_RESTART_NEEDED:    ErrZypperRestartNeeded,
+	ZYPPER_EXIT_INF_CAP_NOT_FOUND:     ErrZypperCapNotFound,
+	ZYPPER_EXIT_ON_SIGNAL:             ErrZypperInterrupted,
+	ZYPPER_EXIT_INF_REPOS_SKIPPED:     ErrZypperRepo,
+	ZYPPER_EXIT_INF_RPM_SCRIPT_FAILED: ErrZypperRpmScript,
+}
+
+type ZypperMessage struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
+type ZypperProgress struct {
+	Id    string `xml:"id,attr"`
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+	Done  string `xml:"done,attr"`
+}
+
+type ZypperSolvable struct {
+	Name       string `xml:"name,attr"`
+	Status     string `xml:"status,attr"`
+	Kind       string `xml:"kind,attr"`
+	Edition    string `xml:"edition,attr"`
+	Arch       string `xml:"arch,attr"`
+	Repository string `xml:"repository,attr"`
+}
+
+// The --xmlout stream of any zypper command
+type ZypperOutput struct {
+	XMLName   xml.Name         `xml:"stream"`
+	Messages  []ZypperMessage  `xml:"message"`
+	Progress  []ZypperProgress `xml:"progress"`
+	Solvables []ZypperSolvable `xml:"search-result>solvable-list>solvable"`
+	ToRemove  []ZypperSolvable `xml:"install-summary>to-remove>solvable"`
+}
+
+func (output *ZypperOutput) messagesOfType(messageType string) []string {
+	messages := []string{}
+	for _, message := range output.Messages {
+		if message.Type == messageType {
+			messages = append(messages, strings.TrimSpace(message.Text))
+		}
+	}
+	return messages
+}
+
+// A failed zypper call, errors.Is matches the ErrZypper* error for the
+// exit code
+type ZypperError struct {
+	Args     []string
+	ExitCode int
+	Messages []string
+	kind     error
+}
+
+func (e *ZypperError) Error() string {
+	message := fmt.Sprintf("zypper %s: %v (exit code %d)", strings.Join(e.Args, " "), e.kind, e.ExitCode)
+	if len(e.Messages) > 0 {
+		message += ": " + strings.Join(e.Messages, "; ")
+	}
+	return message
+}
+
+func (e *ZypperError) Unwrap() error {
+	return e.kind
+}
+
+// Runs zypper non interactively with XML output. When zypp is locked
+// by another process the command is retried until the lock wait budget
+// of the host is used up.
+type ZypperClient struct {
+	OnLockWait func(holder ZyppLockHolder, wait time.Duration)
+	host       *Host
+	sleep      func(wait time.Duration)
+}
+
+// Lock waits are reported through the OnZyppLockWait of the host
+func newZypperClient(host *Host) *ZypperClient {
+	return &ZypperClient{
+		OnLockWait: host.OnZyppLockWait,
+		host:       host,
+		sleep:      time.Sleep,
+	}
+}
+
+func (client *ZypperClient) run(args ...string) (*ZypperOutput, error) {
+	host := client.host
+	for retry := 0; ; retry++ {
+		output, err := client.runOnce(args...)
+		remaining := host.ZyppLockMaxWait - host.zyppLockWaited
+		if !errors.Is(err, ErrZypperLocked) || remaining <= 0 {
+			return output, err
+		}
+		wait := _zyppLockBackoff(retry)
+		if wait > remaining {
+			wait = remaining
+		}
+		client.OnLockWait(_getZyppLockHolder(ZYPP_LOCK_FILE, "/proc"), wait)
+		client.sleep(wait)
+		host.zyppLockWaited += wait
+	}
+}
+
+func (client *ZypperClient) runOnce(args ...string) (*ZypperOutput, error) {
+	zypperArgs := append([]string{"--xmlout", "--non-interactive"}, args...)
+	stdout, exitCode, runError := client.host.RunShellCommandWithExitCode(0, "zypper", zypperArgs...)
+	output := &ZypperOutput{}
+	parseError := xml.Unmarshal([]byte(stdout), output)
+	for _, message := range output.messagesOfType("warning") {
+		fmt.Fprintln(os.Stderr, "zypper warning:", message)
+	}
+
+	switch exitCode {
+	case ZYPPER_EXIT_OK, ZYPPER_EXIT_INF_UPDATE_NEEDED, ZYPPER_EXIT_INF_SEC_UPDATE_NEEDED:
+		if parseError != nil {
+			return output, fmt.Errorf("Could not parse zypper output: %v", parseError)
+		}
+		return output, nil
+	case -1:
+		// zypper did not run to completion
+		return output, runError
+	}
+	kind, ok := zypperExitErrors[exitCode]
+	if !ok {
+		kind = ErrZypper
+	}
+	return output, &ZypperError{
+		Args:     args,
+		ExitCode: exitCode,
+		Messages: output.messagesOfType("error"),
+		kind:     kind,
+	}
+}
+
+func (client *ZypperClient) AddRepo(repoUrl string, repoAlias string) error {
+	_, err := client.run("addrepo", repoUrl, repoAlias)
+	return err
+}
+
+func (client *ZypperClient) RemoveRepo(repoAlias string) error {
+	_, err := client.run("removerepo", repoAlias)
+	return err
+}
+
+func (client *ZypperClient) Refresh(repoAliases ...string) error {
+	_, err := client.run(append([]string{"refresh"}, repoAliases...)...)
+	return err
+}
+
+// Install the given packages or capabilities. When a reboot is needed
+// the packages are installed and ErrZypperRebootNeeded is returned.
+// When zypper only updated itself the install is run a second time.
+func (client *ZypperClient) Install(packages ...string) error {
+	args := append([]string{"install", "--replacefiles", "--no-recommends"}, packages...)
+	_, err := client.run(args...)
+	if errors.Is(err, ErrZypperRestartNeeded) {
+		fmt.Println("zypper updated itself, installing again")
+		_, err = client.run(args...)
+	}
+	return err
+}
+
+func (client *ZypperClient) Remove(packages ...string) error {
+	_, err := client.run(append([]string{"remove"}, packages...)...)
+	return err
+}
+
+// Names of the packages removing the given ones takes along, the given
+// ones included. Nothing is removed.
+func (client *ZypperClient) RemovalSet(packages ...string) ([]string, error) {
+	output, err := client.run(append([]string{"remove", "--dry-run"}, packages...)...)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, solvable := range output.ToRemove {
+		names = append(names, solvable.Name)
+	}
+	return names, nil
+}
+
+// Search packages by exact name, nothing found is an empty result
+func (client *ZypperClient) Search(installedOnly bool, names ...string) ([]ZypperSolvable, error) {
+	args := []string{"search", "--match-exact", "--details"}
+	if installedOnly {
+		args = append(args, "--installed-only")
+	}
+	output, err := client.run(append(args, names...)...)
+	if errors.Is(err, ErrZypperCapNotFound) {
+		return []ZypperSolvable{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return output.Solvables, nil
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2022, SUSE LLC, All rights reserved.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to
+// deal in the Software without restriction, including without limitation the
+// rights to use, copy, modify, merge, publish, distribute, sublicense, and/or
+// sell copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL
+// THE AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+// SOFTWARE.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testZypperOutput = "<?xml version='1.0'?>\n<stream>\n</stream>\n"
+
+func _zypperCommand(exitCode int, args ...string) ScriptedCommand {
+	return ScriptedCommand{
+		Name:   "zypper",
+		Args:   append([]string{"--xmlout", "--non-interactive"}, args...),
+		Result: CommandResult{Stdout: testZypperOutput, ExitCode: exitCode},
+	}
+}
+
+// Zypper client on a test host whose lock waits are recorded instead
+func newTestZypperClient(t *testing.T, commands ...ScriptedCommand) (*ZypperClient, *ScriptedCommandRunner, *[]time.Duration) {
+	runner := &ScriptedCommandRunner{Commands: commands}
+	client := newZypperClient(newTestHost(t, runner))
+	waits := &[]time.Duration{}
+	client.OnLockWait = func(holder ZyppLockHolder, wait time.Duration) {
+		*waits = append(*waits, wait)
+	}
+	client.sleep = func(time.Duration) {}
+	return client, runner, waits
+}
+
+func TestZypperRetriesWhileLocked(t *testing.T) {
+	client, runner, waits := newTestZypperClient(t,
+		_zypperCommand(ZYPPER_EXIT_ZYPP_LOCKED, "refresh"),
+		_zypperCommand(ZYPPER_EXIT_ZYPP_LOCKED, "refresh"),
+		_zypperCommand(ZYPPER_EXIT_OK, "refresh"),
+		_zypperCommand(ZYPPER_EXIT_ZYPP_LOCKED, "addrepo", "https://example.com/repo", "repo"),
+	)
+	client.host.ZyppLockMaxWait = 7 * time.Second
+
+	require.NoError(t, client.Refresh())
+	// the second wait is capped to what is left of the budget
+	assert.Equal(t, []time.Duration{5 * time.Second, 2 * time.Second}, *waits)
+
+	// the budget is shared, the next call does not wait anymore
+	err := client.AddRepo("https://example.com/repo", "repo")
+	assert.True(t, errors.Is(err, ErrZypperLocked), "%v", err)
+	assert.Len(t, *waits, 2)
+	assert.True(t, runner.Done())
+}
+
+func TestZypperExitCodes(t *testing.T) {
+	tests := []struct {
+		exitCode int
+		expected error
+	}{
+		{ZYPPER_EXIT_OK, nil},
+		{ZYPPER_EXIT_INF_UPDATE_NEEDED, nil},
+		{ZYPPER_EXIT_INF_SEC_UPDATE_NEEDED, nil},
+		{ZYPPER_EXIT_ERR_BUG, ErrZypper},
+		{ZYPPER_EXIT_ERR_SYNTAX, ErrZypperInvalidArgs},
+		{ZYPPER_EXIT_ERR_INVALID_ARGS, ErrZypperInvalidArgs},
+		{ZYPPER_EXIT_ERR_ZYPP, ErrZypper},
+		{ZYPPER_EXIT_ERR_PRIVILEGES, ErrZypperPrivileges},
+		{ZYPPER_EXIT_NO_REPOS, ErrZypperRepo},
+		{ZYPPER_EXIT_ZYPP_LOCKED, ErrZypperLocked},
+		{ZYPPER_EXIT_ERR_COMMIT, ErrZypperCommit},
+		{ZYPPER_EXIT_INF_REBOOT_NEEDED, ErrZypperRebootNeeded},
+		{ZYPPER_EXIT_INF_CAP_NOT_FOUND, ErrZypperCapNotFound},
+		{ZYPPER_EXIT_ON_SIGNAL, ErrZypperInterrupted},
+		{ZYPPER_EXIT_INF_REPOS_SKIPPED, ErrZypperRepo},
+		{ZYPPER_EXIT_INF_RPM_SCRIPT_FAILED, ErrZypperRpmScript},
+		// not documented
+		{42, ErrZypper},
+	}
+	for _, test := range tests {
+		t.Run(fmt.Sprint(test.exitCode), func(t *testing.T) {
+			client, runner, _ := newTestZypperClient(t, _zypperCommand(test.exitCode, "install", "--replacefiles", "--no-recommends", "pkg"))
+			// no waiting for the lock
+			client.host.ZyppLockMaxWait = 0
+			err := client.Install("pkg")
+			if test.expected == nil {
+				assert.NoError(t, err)
+			} else {
+				var zypperError *ZypperError
+				require.True(t, errors.As(err, &zypperError), "%v", err)
+				assert.Equal(t, test.exitCode, zypperError.ExitCode)
+				assert.True(t, errors.Is(err, test.expected), "%v", err)
+			}
+			assert.True(t, runner.Done())
+		})
+	}
+}
+
+func TestZypperErrorMessages(t *testing.T) {
+	command := _zypperCommand(ZYPPER_EXIT_INF_CAP_NOT_FOUND, "install", "--replacefiles", "--no-recommends", "pkg")
+	command.Result.Stdout = "<?xml version='1.0'?>\n<stream>\n" +
+		"<message type=\"error\">No provider of 'pkg' found.</message>\n</stream>\n"
+	client, _, _ := newTestZypperClient(t, command)
+	err := client.Install("pkg")
+	require.Error(t, err)
+	assert.Equal(t, "zypper install --replacefiles --no-recommends pkg: package not found (exit code 104): No provider of 'pkg' found.", err.Error())
+}
+
+func TestZypperInstallRunsAgainAfterUpdatingItself(t *testing.T) {
+	install := []string{"install", "--replacefiles", "--no-recommends", "pkg"}
+	client, runner, _ := newTestZypperClient(t,
+		_zypperCommand(ZYPPER_EXIT_INF_RESTART_NEEDED, install...),
+		_zypperCommand(ZYPPER_EXIT_OK, install...),
+	)
+	require.NoError(t, client.Install("pkg"))
+	assert.True(t, runner.Done())
+
+	// only once
+	client, runner, _ = newTestZypperClient(t,
+		_zypperCommand(ZYPPER_EXIT_INF_RESTART_NEEDED, install...),
+		_zypperCommand(ZYPPER_EXIT_INF_RESTART_NEEDED, install...),
+	)
+	err := client.Install("pkg")
+	assert.True(t, errors.Is(err, ErrZypperRestartNeeded), "%v", err)
+	assert.True(t, runner.Done())
+}
+
+func TestZypperUnparsableOutput(t *testing.T) {
+	command := _zypperCommand(ZYPPER_EXIT_OK, "refresh")
+	command.Result.Stdout = "Repository 'SLES' is up to date."
+	client, _, _ := newTestZypperClient(t, command)
+	assert.Error(t, client.Refresh())
+}
+
+func TestZypperSearchNothingFound(t *testing.T) {
+	client, _, _ := newTestZypperClient(t,
+		_zypperCommand(ZYPPER_EXIT_INF_CAP_NOT_FOUND, "search", "--match-exact", "--details", "--installed-only", "pkg"))
+	solvables, err := client.Search(true, "pkg")
+	require.NoError(t, err)
+	assert.Empty(t, solvables)
+}